@@ -0,0 +1,223 @@
+package admission
+
+import (
+	"backend/config"
+	"backend/jobs"
+	"backend/models"
+	"backend/retention"
+	util "backend/utils"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInsufficientSpace = errors.New("not enough storage space for this download")
+	ErrQuotaExceeded     = errors.New("user storage quota exceeded")
+)
+
+// Reservation holds space for a job until its file is handed to retention
+type Reservation struct {
+	id     string
+	userID string
+	bytes  int64
+}
+
+var (
+	reservations = make(map[string]*Reservation)
+	mu           sync.Mutex
+
+	// Closed and replaced every time space is released, wakes queued jobs
+	released = make(chan struct{})
+)
+
+// Reserve admits req or fails with ErrInsufficientSpace / ErrQuotaExceeded.
+// In queue mode a job that doesn't fit waits for space until the queue
+// timeout, calling waiting once so the client can be told.
+func Reserve(ctx context.Context, req models.DownloadVideoRequest, waiting func(estimate int64, guessed bool)) (*Reservation, error) {
+	cfg := config.Get()
+	need, guessed := EstimateBytes(req)
+	userID := req.OriginalReq.UserID
+
+	deadline := time.NewTimer(cfg.AdmissionQueueTimeout)
+	defer deadline.Stop()
+
+	notified := false
+
+	for {
+		mu.Lock()
+		err := fits(userID, need)
+		if err == nil {
+			r := &Reservation{id: req.RequestID, userID: userID, bytes: need}
+			reservations[req.RequestID] = r
+			mu.Unlock()
+
+			log.Printf("[ADMISSION] Reserved | RequestID=%s | User=%s | Bytes=%d | Guessed=%t", req.RequestID, userID, need, guessed)
+			return r, nil
+		}
+		wake := released
+		mu.Unlock()
+
+		// Waiting doesn't free a user's quota, only their files expiring does
+		if errors.Is(err, ErrQuotaExceeded) || cfg.AdmissionMode == "reject" {
+			return nil, err
+		}
+
+		if !notified {
+			waiting(need, guessed)
+			notified = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-deadline.C:
+			return nil, err
+		case <-wake:
+		case <-time.After(10 * time.Second):
+			// Retention frees space without telling us
+		}
+	}
+}
+
+// Release gives the reserved space back, the finished file is accounted by retention from now on
+func (r *Reservation) Release() {
+	if r == nil {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if reservations[r.id] != r {
+		return
+	}
+	delete(reservations, r.id)
+
+	close(released)
+	released = make(chan struct{})
+}
+
+// fits must be called with mu held
+func fits(userID string, need int64) error {
+	cfg := config.Get()
+
+	var reserved, userReserved int64
+	for _, r := range reservations {
+		reserved += r.bytes
+		if userID != "" && r.userID == userID {
+			userReserved += r.bytes
+		}
+	}
+
+	if cfg.UserQuotaBytes > 0 && userID != "" {
+		used := retention.BytesOwnedBy(func(requestID string) bool {
+			record := jobs.Lookup(requestID)
+			return record != nil && record.Request.OriginalReq.UserID == userID
+		})
+		if used+userReserved+need > cfg.UserQuotaBytes {
+			return fmt.Errorf("%w: %d of %d bytes used", ErrQuotaExceeded, used+userReserved, cfg.UserQuotaBytes)
+		}
+	}
+
+	if cfg.DownloadsBudgetBytes > 0 && retention.TrackedBytes()+reserved+need > cfg.DownloadsBudgetBytes {
+		return ErrInsufficientSpace
+	}
+
+	if free, err := util.DiskFree("downloads"); err == nil {
+		if free-reserved-need < cfg.RetentionMinFreeBytes {
+			return ErrInsufficientSpace
+		}
+	}
+
+	return nil
+}
+
+// Rough average bitrates in kbit/s, used when the extractor doesn't report a size
+var qualityBitrates = map[string]int64{
+	"144p":  150,
+	"240p":  300,
+	"360p":  700,
+	"480p":  1200,
+	"720p":  2500,
+	"1080p": 5000,
+	"1440p": 10000,
+}
+
+const (
+	defaultBitrate  = 5000
+	audioBitrate    = 192
+	unknownDuration = 15 * 60
+
+	// A recording reserves its first half hour only, holding space for
+	// hours it may never run would keep other jobs out. Past that it's
+	// covered by the free space retention keeps.
+	liveWindow = 30 * 60
+)
+
+// EstimateBytes guesses the disk space a job needs, including the separate
+// video and audio streams that sit next to the merged output until they are
+// removed. guessed is true when the duration wasn't known and a default was used.
+func EstimateBytes(req models.DownloadVideoRequest) (bytes int64, guessed bool) {
+	duration := req.Duration
+	if req.IsLive {
+		duration = liveWindow
+		if limit := float64(req.OriginalReq.MaxDuration); limit > 0 && limit < duration {
+			duration = limit
+		}
+	}
+	if clip := clipSeconds(req.OriginalReq.ClipStart, req.OriginalReq.ClipEnd, duration); clip > 0 {
+		duration = clip
+	}
+	if duration <= 0 {
+		duration, guessed = unknownDuration, true
+	}
+
+	if req.OriginalReq.AudioOnly {
+		// Source audio plus the converted mp3
+		return int64(duration) * audioBitrate * 1000 / 8 * 2, guessed
+	}
+
+	bitrate, ok := qualityBitrates[req.OriginalReq.Quality]
+	if !ok {
+		bitrate = defaultBitrate
+	}
+
+	// Separate streams plus the merged output, or a live recording plus its remux
+	return int64(duration) * (bitrate + audioBitrate) * 1000 / 8 * 2, guessed
+}
+
+// clipSeconds returns the length of the requested clip, 0 when there is none or it can't be parsed
+func clipSeconds(start, end string, duration float64) float64 {
+	if start == "" && end == "" {
+		return 0
+	}
+
+	from := parseTimestamp(start)
+	to := parseTimestamp(end)
+	if end == "" {
+		to = duration
+	}
+	if to <= from {
+		return 0
+	}
+	return to - from
+}
+
+// parseTimestamp accepts seconds or [[hh:]mm:]ss
+func parseTimestamp(value string) float64 {
+	var total float64
+	for _, part := range strings.Split(value, ":") {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0
+		}
+		total = total*60 + v
+	}
+	return total
+}
@@ -0,0 +1,132 @@
+package admission
+
+import (
+	"testing"
+
+	"backend/models"
+)
+
+func TestEstimateBytes(t *testing.T) {
+	const (
+		// Video plus audio stream at 720p, doubled for the merged output
+		perSecond720p  = (2500 + audioBitrate) * 1000 / 8 * 2
+		perSecond1080p = (5000 + audioBitrate) * 1000 / 8 * 2
+		perSecondAudio = audioBitrate * 1000 / 8 * 2
+	)
+
+	tests := []struct {
+		name    string
+		req     models.DownloadVideoRequest
+		bytes   int64
+		guessed bool
+	}{
+		{
+			name:  "known duration",
+			req:   request("720p", 600),
+			bytes: 600 * perSecond720p,
+		},
+		{
+			name:    "unknown duration",
+			req:     request("1080p", 0),
+			bytes:   unknownDuration * perSecond1080p,
+			guessed: true,
+		},
+		{
+			name:  "unknown quality counts as 1080p",
+			req:   request("best", 600),
+			bytes: 600 * perSecond1080p,
+		},
+		{
+			name:  "audio only",
+			req:   audio(request("720p", 600)),
+			bytes: 600 * perSecondAudio,
+		},
+		{
+			name:  "clip",
+			req:   clip(request("720p", 600), "00:01:30", "120"),
+			bytes: 30 * perSecond720p,
+		},
+		{
+			name:  "clip to the end",
+			req:   clip(request("720p", 600), "500", ""),
+			bytes: 100 * perSecond720p,
+		},
+		{
+			name:  "clip ending before it starts",
+			req:   clip(request("720p", 600), "2:00", "1:00"),
+			bytes: 600 * perSecond720p,
+		},
+		{
+			name:    "clip to the end of an unknown duration",
+			req:     clip(request("720p", 0), "30", ""),
+			bytes:   unknownDuration * perSecond720p,
+			guessed: true,
+		},
+		{
+			name:  "live",
+			req:   live(request("720p", 0), 0),
+			bytes: liveWindow * perSecond720p,
+		},
+		{
+			name:  "live with a shorter limit",
+			req:   live(request("720p", 0), 600),
+			bytes: 600 * perSecond720p,
+		},
+		{
+			name:  "live with a longer limit",
+			req:   live(request("720p", 0), 4*3600),
+			bytes: liveWindow * perSecond720p,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bytes, guessed := EstimateBytes(tt.req)
+			if bytes != tt.bytes || guessed != tt.guessed {
+				t.Fatalf("EstimateBytes = %d, %v, want %d, %v", bytes, guessed, tt.bytes, tt.guessed)
+			}
+		})
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	tests := map[string]float64{
+		"90":         90,
+		"1:30":       90,
+		"01:01:30":   3690,
+		"12.5":       12.5,
+		"":           0,
+		"1:xx":       0,
+		"ninety":     0,
+		"00:00:00.5": 0.5,
+	}
+	for value, want := range tests {
+		if got := parseTimestamp(value); got != want {
+			t.Errorf("parseTimestamp(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func request(quality string, duration float64) models.DownloadVideoRequest {
+	return models.DownloadVideoRequest{
+		RequestID:   "0123456789abcdef",
+		Duration:    duration,
+		OriginalReq: models.Request{Quality: quality},
+	}
+}
+
+func audio(r models.DownloadVideoRequest) models.DownloadVideoRequest {
+	r.OriginalReq.AudioOnly = true
+	return r
+}
+
+func clip(r models.DownloadVideoRequest, start, end string) models.DownloadVideoRequest {
+	r.OriginalReq.ClipStart, r.OriginalReq.ClipEnd = start, end
+	return r
+}
+
+func live(r models.DownloadVideoRequest, maxDuration int) models.DownloadVideoRequest {
+	r.IsLive = true
+	r.OriginalReq.MaxDuration = maxDuration
+	return r
+}
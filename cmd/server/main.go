@@ -1,37 +1,90 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
-	"time"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"backend/config"
+	controllers "backend/controller"
+	"backend/cookies"
+	"backend/deps"
+	"backend/downloader"
+	"backend/pipeline"
+	"backend/profiles"
+	"backend/proxy"
+	"backend/ratelimit"
+	"backend/retention"
 	"backend/router"
-	utils "backend/utils"
+	"backend/storage"
 
 	"github.com/rs/cors"
 )
 
 func main() {
-	go func() {
-		for {
-			if err := utils.DeleteFilesOlderThan("downloads", 24); err != nil {
-				log.Printf("[MAIN.go] Cleanup error: %v", err)
-			}
-			time.Sleep(1 * time.Hour)
-		}
-	}()
+	if err := storage.Setup(config.Get()); err != nil {
+		log.Fatalf("[MAIN.go] Storage setup failed: %v", err)
+	}
+
+	if err := proxy.Setup(config.Get()); err != nil {
+		log.Fatalf("[MAIN.go] Proxy setup failed: %v", err)
+	}
+
+	if err := ratelimit.Setup(config.Get()); err != nil {
+		log.Fatalf("[MAIN.go] Rate limit setup failed: %v", err)
+	}
+
+	if err := pipeline.Setup(config.Get()); err != nil {
+		log.Fatalf("[MAIN.go] Pipeline setup failed: %v", err)
+	}
+
+	if err := downloader.Setup(config.Get()); err != nil {
+		log.Fatalf("[MAIN.go] Downloader setup failed: %v", err)
+	}
+
+	if err := profiles.Load(); err != nil {
+		log.Fatalf("[MAIN.go] Runner profiles setup failed: %v", err)
+	}
+	go reloadProfilesOnHangup()
+
+	if err := cookies.Load(); err != nil {
+		log.Printf("[MAIN.go] Cookie jars not loaded: %v", err)
+	}
+
+	deps.Check(context.Background())
+	deps.Start(context.Background())
+
+	// Load unfinished jobs before the first sweep so their .part files are kept
+	controllers.ResumeJobs()
+
+	retention.Restore()
+	retention.Start(context.Background())
 
 	r := router.SetupRouter()
 
-corsHandler := cors.New(cors.Options{
-    AllowedOrigins:   []string{"*"},
-    AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-    AllowedHeaders:   []string{"Authorization", "Content-Type"},
-    AllowCredentials: false,
-}).Handler(r)
+	corsHandler := cors.New(cors.Options{
+		AllowedOrigins:   []string{"*"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type"},
+		AllowCredentials: false,
+	}).Handler(r)
 
 	log.Println("[MAIN.GO] Server running at http://localhost:8080")
 	if err := http.ListenAndServe(":8080", corsHandler); err != nil {
 		log.Fatalf("[MAIN.Go] Server failed: %v", err)
 	}
 }
+
+// reloadProfilesOnHangup rereads the runner profiles on SIGHUP
+func reloadProfilesOnHangup() {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	for range hangup {
+		if err := profiles.Load(); err != nil {
+			log.Printf("[PROFILES] Reload failed, keeping current profiles: %v", err)
+		}
+	}
+}
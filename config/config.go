@@ -0,0 +1,314 @@
+package config
+
+import (
+	"crypto/rand"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config holds the settings read from the environment at startup
+type Config struct {
+	// Secret used to sign /downloads links, random per process when unset
+	DownloadSigningKey []byte
+
+	// Retention: "cleanup_at" honors the per file CleanupAt, "ttl" keeps
+	// every file for RetentionTTL, "after_download" deletes a file once it
+	// was downloaded in full
+	RetentionPolicy        string
+	RetentionTTL           time.Duration
+	RetentionSweepInterval time.Duration
+	// Least recently used files are evicted while free disk space is below
+	// this or the downloads directory is above RetentionMaxBytes (0 = no cap)
+	RetentionMinFreeBytes int64
+	RetentionMaxBytes     int64
+
+	// Admission: bytes the downloads directory may use (0 = only free disk
+	// space counts), bytes each UserID may hold (0 = unlimited), and whether
+	// jobs that don't fit wait ("queue") or fail right away ("reject")
+	DownloadsBudgetBytes  int64
+	UserQuotaBytes        int64
+	AdmissionMode         string
+	AdmissionQueueTimeout time.Duration
+
+	// Storage backend for finished files: "local", "s3", "webdav" or "sftp".
+	// Downloads are always written to ./downloads first.
+	StorageBackend string
+
+	S3Endpoint  string
+	S3Region    string
+	S3Bucket    string
+	S3AccessKey string
+	S3SecretKey string
+	S3PathStyle bool
+
+	WebDAVURL      string
+	WebDAVUser     string
+	WebDAVPassword string
+
+	SFTPAddr       string
+	SFTPUser       string
+	SFTPPassword   string
+	SFTPKeyFile    string
+	SFTPKnownHosts string
+	SFTPHostKey    string // authorized_keys format, used when there is no known_hosts file
+	SFTPRoot       string
+
+	// POST /stream-download only serves clips up to this length, longer
+	// videos have to go through the regular download flow
+	StreamMaxDuration time.Duration
+
+	// Bearer token for the /admin routes, they are disabled when unset
+	AdminToken string
+
+	// Cookie jars are encrypted with a key derived from CookieEncryptionKey,
+	// a random key is used when unset. Platforms without a jar fall back to
+	// CookiesFromBrowser, e.g. "firefox" on a desktop, or no cookies at all.
+	CookieEncryptionKey []byte
+	CookiesFromBrowser  string
+
+	// Proxies: PROXIES is a comma separated list used for every platform,
+	// PROXY_CONFIG a JSON file that can limit proxies to platforms.
+	// ProxySelection is "round_robin" or "sticky" (one proxy per job).
+	Proxies         []string
+	ProxyConfigFile string
+	ProxySelection  string
+	ProxyQuarantine time.Duration
+
+	// Failed downloads are retried up to RetryMaxAttempts times in total,
+	// waiting RetryBaseDelay, doubled per attempt, between them
+	RetryMaxAttempts int
+	RetryBaseDelay   time.Duration
+
+	// Unfinished jobs are picked up again after a restart at most this often
+	JobMaxResumes int
+
+	// Time limits of yt-dlp and ffmpeg children, 0 disables one. Live
+	// recordings are bounded by their own maximum duration instead.
+	InfoTimeout     time.Duration
+	DownloadTimeout time.Duration
+	FFmpegTimeout   time.Duration
+
+	// Downloads larger than MaxFileSize bytes or videos longer than
+	// MaxDuration are refused, 0 for no limit
+	MaxFileSize int64
+	MaxDuration time.Duration
+
+	// Children run with this nice value and the lowest IO priority, 0 keeps
+	// the server's. With ProcessMemoryLimit set every yt-dlp or ffmpeg run
+	// gets its own cgroup under CgroupRoot, a delegated cgroup v2 directory.
+	ProcessNice        int
+	ProcessMemoryLimit int64
+	CgroupRoot         string
+
+	// Requests per minute and concurrent jobs allowed per platform, 0 for no
+	// limit. RATE_LIMITS overrides platforms, e.g. "TikTok=20/2,Instagram=10/1".
+	RateLimitRPM         int
+	RateLimitConcurrency int
+	RateLimits           []string
+
+	// DOWNLOADERS assigns a backend to platforms, e.g. "Pinterest=gallery-dl".
+	// Direct media links are fetched over DirectConnections ranged requests.
+	Downloaders       []string
+	DirectConnections int
+
+	// BinDir holds the managed yt-dlp, used instead of the one on PATH once
+	// an admin installed it. Updates are checked against YTDLPChecksumURL,
+	// "none" skips the check.
+	BinDir           string
+	YTDLPUpdateURL   string
+	YTDLPChecksumURL string
+
+	// Each job's tool output is kept in data/logs, up to JobLogMaxBytes.
+	// Logs of jobs without a retained file are deleted after JobLogTTL.
+	JobLogMaxBytes int64
+	JobLogTTL      time.Duration
+
+	// Records of finished jobs without a retained file are deleted
+	// JobRecordTTL after their last change
+	JobRecordTTL time.Duration
+
+	// JSON file of per-platform yt-dlp settings, see package profiles
+	RunnerProfilesFile string
+
+	// Workers of each pipeline stage: network downloads, ffmpeg conversions,
+	// probing and checksums, storage uploads. An ffmpeg run uses several
+	// threads, merges default to half the CPUs.
+	FetchWorkers       int
+	MergeWorkers       int
+	PostProcessWorkers int
+	UploadWorkers      int
+
+	// yt-dlp fragment connections shared by all downloads, 0 for no limit.
+	// A platform that gave earlier downloads FragmentTargetSpeed bytes per
+	// second with fewer connections gets fewer.
+	FragmentBudget      int
+	FragmentTargetSpeed int64
+}
+
+var (
+	current *Config
+	once    sync.Once
+)
+
+func Get() *Config {
+	once.Do(func() {
+		current = load()
+	})
+	return current
+}
+
+func load() *Config {
+	cfg := &Config{
+		DownloadSigningKey: []byte(os.Getenv("DOWNLOAD_SIGNING_KEY")),
+
+		RetentionPolicy:        envString("RETENTION_POLICY", "cleanup_at"),
+		RetentionTTL:           envDuration("RETENTION_TTL", 24*time.Hour),
+		RetentionSweepInterval: envDuration("RETENTION_SWEEP_INTERVAL", time.Minute),
+		RetentionMinFreeBytes:  envInt64("RETENTION_MIN_FREE_BYTES", 2<<30),
+		RetentionMaxBytes:      envInt64("RETENTION_MAX_BYTES", 0),
+
+		DownloadsBudgetBytes:  envInt64("DOWNLOADS_BUDGET_BYTES", 0),
+		UserQuotaBytes:        envInt64("USER_QUOTA_BYTES", 0),
+		AdmissionMode:         envString("ADMISSION_MODE", "queue"),
+		AdmissionQueueTimeout: envDuration("ADMISSION_QUEUE_TIMEOUT", 10*time.Minute),
+
+		StorageBackend: envString("STORAGE_BACKEND", "local"),
+
+		S3Endpoint:  envString("S3_ENDPOINT", "https://s3.amazonaws.com"),
+		S3Region:    envString("S3_REGION", "us-east-1"),
+		S3Bucket:    os.Getenv("S3_BUCKET"),
+		S3AccessKey: os.Getenv("S3_ACCESS_KEY"),
+		S3SecretKey: os.Getenv("S3_SECRET_KEY"),
+		S3PathStyle: envBool("S3_PATH_STYLE", false),
+
+		WebDAVURL:      os.Getenv("WEBDAV_URL"),
+		WebDAVUser:     os.Getenv("WEBDAV_USER"),
+		WebDAVPassword: os.Getenv("WEBDAV_PASSWORD"),
+
+		SFTPAddr:       os.Getenv("SFTP_ADDR"),
+		SFTPUser:       os.Getenv("SFTP_USER"),
+		SFTPPassword:   os.Getenv("SFTP_PASSWORD"),
+		SFTPKeyFile:    os.Getenv("SFTP_KEY_FILE"),
+		SFTPKnownHosts: os.Getenv("SFTP_KNOWN_HOSTS"),
+		SFTPHostKey:    os.Getenv("SFTP_HOST_KEY"),
+		SFTPRoot:       envString("SFTP_ROOT", "downloads"),
+
+		StreamMaxDuration: envDuration("STREAM_MAX_DURATION", 10*time.Minute),
+
+		AdminToken: os.Getenv("ADMIN_TOKEN"),
+
+		CookieEncryptionKey: []byte(os.Getenv("COOKIE_ENCRYPTION_KEY")),
+		CookiesFromBrowser:  os.Getenv("COOKIES_FROM_BROWSER"),
+
+		Proxies:         envList("PROXIES"),
+		ProxyConfigFile: os.Getenv("PROXY_CONFIG"),
+		ProxySelection:  envString("PROXY_SELECTION", "round_robin"),
+		ProxyQuarantine: envDuration("PROXY_QUARANTINE", 5*time.Minute),
+
+		RetryMaxAttempts: int(envInt64("RETRY_MAX_ATTEMPTS", 3)),
+		RetryBaseDelay:   envDuration("RETRY_BASE_DELAY", 5*time.Second),
+
+		JobMaxResumes: int(envInt64("JOB_MAX_RESUMES", 3)),
+
+		InfoTimeout:     envDuration("INFO_TIMEOUT", time.Minute),
+		DownloadTimeout: envDuration("DOWNLOAD_TIMEOUT", 2*time.Hour),
+		FFmpegTimeout:   envDuration("FFMPEG_TIMEOUT", 30*time.Minute),
+
+		MaxFileSize: envInt64("MAX_FILE_SIZE", 0),
+		MaxDuration: envDuration("MAX_DURATION", 0),
+
+		ProcessNice:        int(envInt64("PROCESS_NICE", 10)),
+		ProcessMemoryLimit: envInt64("PROCESS_MEMORY_LIMIT", 0),
+		CgroupRoot:         envString("CGROUP_ROOT", "/sys/fs/cgroup/prodl"),
+
+		RateLimitRPM:         int(envInt64("RATE_LIMIT_RPM", 30)),
+		RateLimitConcurrency: int(envInt64("RATE_LIMIT_CONCURRENCY", 4)),
+		RateLimits:           envList("RATE_LIMITS"),
+
+		Downloaders:       envList("DOWNLOADERS"),
+		DirectConnections: int(envInt64("DIRECT_CONNECTIONS", 4)),
+
+		BinDir:           envString("BIN_DIR", "data/bin"),
+		YTDLPUpdateURL:   envString("YTDLP_UPDATE_URL", "https://github.com/yt-dlp/yt-dlp/releases/latest/download/yt-dlp"),
+		YTDLPChecksumURL: envString("YTDLP_CHECKSUM_URL", "https://github.com/yt-dlp/yt-dlp/releases/latest/download/SHA2-256SUMS"),
+
+		JobLogMaxBytes: envInt64("JOB_LOG_MAX_BYTES", 1<<20),
+		JobLogTTL:      envDuration("JOB_LOG_TTL", 72*time.Hour),
+		JobRecordTTL:   envDuration("JOB_RECORD_TTL", 72*time.Hour),
+
+		RunnerProfilesFile: os.Getenv("RUNNER_PROFILES"),
+
+		FetchWorkers:       int(envInt64("FETCH_WORKERS", 25)),
+		MergeWorkers:       int(envInt64("MERGE_WORKERS", int64(max(runtime.NumCPU()/2, 1)))),
+		PostProcessWorkers: int(envInt64("POSTPROCESS_WORKERS", int64(runtime.NumCPU()))),
+		UploadWorkers:      int(envInt64("UPLOAD_WORKERS", 8)),
+
+		FragmentBudget:      int(envInt64("FRAGMENT_BUDGET", 64)),
+		FragmentTargetSpeed: envInt64("FRAGMENT_TARGET_SPEED", 32<<20),
+	}
+
+	if cfg.YTDLPChecksumURL == "none" {
+		cfg.YTDLPChecksumURL = ""
+	}
+
+	if len(cfg.DownloadSigningKey) == 0 {
+		log.Println("[CONFIG] DOWNLOAD_SIGNING_KEY not set, download links will not survive a restart")
+		cfg.DownloadSigningKey = make([]byte, 32)
+		rand.Read(cfg.DownloadSigningKey)
+	}
+
+	if len(cfg.CookieEncryptionKey) == 0 {
+		log.Println("[CONFIG] COOKIE_ENCRYPTION_KEY not set, cookie jars will not survive a restart")
+		cfg.CookieEncryptionKey = make([]byte, 32)
+		rand.Read(cfg.CookieEncryptionKey)
+	}
+
+	if cfg.AdminToken == "" {
+		log.Println("[CONFIG] ADMIN_TOKEN not set, admin routes are disabled")
+	}
+
+	return cfg
+}
+
+func envInt64(key string, def int64) int64 {
+	if v, err := strconv.ParseInt(os.Getenv(key), 10, 64); err == nil {
+		return v
+	}
+	return def
+}
+
+func envBool(key string, def bool) bool {
+	if v, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return v
+	}
+	return def
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	if v, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return v
+	}
+	return def
+}
+
+func envList(key string) []string {
+	var list []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
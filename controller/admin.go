@@ -0,0 +1,34 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"backend/config"
+)
+
+// AdminAuth only lets requests with "Authorization: Bearer <ADMIN_TOKEN>" through
+func AdminAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := config.Get().AdminToken
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"error": "Admin API is disabled",
+			})
+			return
+		}
+
+		given, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "Unauthorized",
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
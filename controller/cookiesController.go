@@ -0,0 +1,167 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"backend/cookies"
+	util "backend/utils"
+)
+
+// Cookie files are small, anything bigger is not a cookies.txt
+const maxCookieFileSize = 1 << 20
+
+type jarResponse struct {
+	cookies.Jar
+	Status string `json:"status"`
+}
+
+func toJarResponse(jar cookies.Jar) jarResponse {
+	return jarResponse{Jar: jar, Status: jar.Status()}
+}
+
+// ListCookieJarsHandler lists the stored jars, never their contents
+func ListCookieJarsHandler(c *gin.Context) {
+	platform := ""
+	if name := c.Query("platform"); name != "" {
+		var ok bool
+		if platform, ok = util.CanonicalPlatform(name); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Unknown platform",
+			})
+			return
+		}
+	}
+
+	jars := []jarResponse{}
+	for _, jar := range cookies.List(platform) {
+		jars = append(jars, toJarResponse(jar))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"jars": jars,
+	})
+}
+
+// UploadCookieJarHandler stores a Netscape cookies.txt for a platform, sent
+// as the request body or as the "file" form field. With ?replace=true the
+// platform's other jars are removed once the new one is stored.
+func UploadCookieJarHandler(c *gin.Context) {
+	platform, ok := util.CanonicalPlatform(c.Param("platform"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Unknown platform",
+		})
+		return
+	}
+
+	data, err := readCookieFile(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	previous := cookies.List(platform)
+
+	jar, warnings, err := cookies.Add(platform, data)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if c.Query("replace") == "true" {
+		for _, old := range previous {
+			if err := cookies.Remove(old.ID); err != nil {
+				log.Printf("[COOKIES] Failed to remove rotated jar | ID=%s | Error=%v", old.ID, err)
+			}
+		}
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"jar":      toJarResponse(*jar),
+		"warnings": warnings,
+	})
+}
+
+// ValidateCookieJarHandler checks a stored jar again and re-enables it when it passes
+func ValidateCookieJarHandler(c *gin.Context) {
+	if !platformJar(c) {
+		return
+	}
+
+	jar, warnings, err := cookies.Revalidate(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"jar":      toJarResponse(*jar),
+		"warnings": warnings,
+	})
+}
+
+func DeleteCookieJarHandler(c *gin.Context) {
+	if !platformJar(c) {
+		return
+	}
+
+	if err := cookies.Remove(c.Param("id")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// platformJar checks that :id is a jar of :platform, it answers 404 otherwise
+func platformJar(c *gin.Context) bool {
+	platform, _ := util.CanonicalPlatform(c.Param("platform"))
+
+	jar, err := cookies.Get(c.Param("id"))
+	if err != nil || jar.Platform != platform {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Cookie jar not found",
+		})
+		return false
+	}
+	return true
+}
+
+func readCookieFile(c *gin.Context) ([]byte, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxCookieFileSize)
+
+	if c.ContentType() == "multipart/form-data" {
+		header, err := c.FormFile("file")
+		if err != nil {
+			return nil, errors.New("cookie file is required")
+		}
+		f, err := header.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return io.ReadAll(f)
+	}
+
+	data, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil, errors.New("cookie file too large")
+	}
+	if len(data) == 0 {
+		return nil, errors.New("cookie file is required")
+	}
+	return data, nil
+}
@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"backend/deps"
+)
+
+// HealthHandler reports whether downloads can run, with the versions of the
+// tools behind them. A missing required tool answers 503. It is public, so
+// paths and errors stay behind DependenciesHandler.
+func HealthHandler(c *gin.Context) {
+	status, code := healthStatus()
+	c.JSON(code, gin.H{
+		"status":   status,
+		"versions": deps.Versions(),
+	})
+}
+
+// DependenciesHandler checks every tool again and reports where it was
+// found and why it failed
+func DependenciesHandler(c *gin.Context) {
+	deps.Check(context.WithoutCancel(c.Request.Context()))
+
+	status, code := healthStatus()
+	c.JSON(code, gin.H{
+		"status":       status,
+		"dependencies": deps.Statuses(),
+	})
+}
+
+func healthStatus() (string, int) {
+	if !deps.Healthy() {
+		return "degraded", http.StatusServiceUnavailable
+	}
+	return "ok", http.StatusOK
+}
+
+// UpdateYTDLPHandler installs the latest yt-dlp release into the managed directory
+func UpdateYTDLPHandler(c *gin.Context) {
+	result, err := deps.UpdateYTDLP(c.Request.Context())
+	if err != nil {
+		respondUpdateFailure(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// RollbackYTDLPHandler puts back the yt-dlp the last update replaced
+func RollbackYTDLPHandler(c *gin.Context) {
+	result, err := deps.RollbackYTDLP(c.Request.Context())
+	if err != nil {
+		respondUpdateFailure(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func respondUpdateFailure(c *gin.Context, err error) {
+	log.Printf("[DEPS] %s failed: %v", c.Request.URL.Path, err)
+
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, deps.ErrUpdateRunning):
+		status = http.StatusConflict
+	case errors.Is(err, deps.ErrNoRollback):
+		status = http.StatusNotFound
+	}
+
+	// Admin only, the details help more than a generic message
+	c.JSON(status, gin.H{
+		"error": err.Error(),
+	})
+}
@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"backend/jobs"
+	"backend/retention"
+	"backend/storage"
+	util "backend/utils"
+)
+
+// DownloadFileHandler serves /downloads/:id/:filename from the storage
+// backend, :filename is only the name the user gets to see.
+// Links are signed with an expiry, see util.SignedDownloadURL.
+func DownloadFileHandler(c *gin.Context) {
+	requestID := c.Param("id")
+	if !util.IsRequestID(requestID) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "File not found",
+		})
+		return
+	}
+
+	name := c.Param("filename")
+	once := c.Query("once") == "1"
+
+	err := util.VerifyDownloadLink(requestID, name, c.Query("expires"), c.Query("sig"), once)
+	switch {
+	case errors.Is(err, util.ErrLinkExpired):
+		c.JSON(http.StatusGone, gin.H{
+			"error": "Download link expired",
+		})
+		return
+	case err != nil:
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Invalid download link",
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	backend := storage.Default()
+
+	key, err := resolveJobKey(c, backend, requestID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "File not found",
+		})
+		return
+	}
+
+	info, err := backend.Stat(ctx, key)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "File not found",
+		})
+		return
+	}
+
+	object, err := backend.Open(ctx, key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to read file",
+		})
+		return
+	}
+	defer object.Close()
+
+	// A transfer cut short leaves the once link for another try
+	done := func(used bool) {}
+	if once && c.Request.Method != http.MethodHead {
+		expires, _ := strconv.ParseInt(c.Query("expires"), 10, 64)
+		done, err = jobs.ClaimLink(requestID, c.Query("sig"), expires)
+		if err != nil {
+			c.JSON(http.StatusGone, gin.H{
+				"error": "Download link already used",
+			})
+			return
+		}
+	}
+
+	c.Header("Content-Type", util.MimeType(key))
+	c.Header("Content-Disposition", util.ContentDisposition(name))
+	c.Header("ETag", fmt.Sprintf(`"%x-%x"`, info.Size, info.ModTime.UnixNano()))
+	c.Header("Cache-Control", "private, no-transform")
+
+	// ServeContent handles Range, If-Range, If-None-Match and Last-Modified
+	http.ServeContent(c.Writer, c.Request, name, info.ModTime, object)
+
+	done(bodyServed(c.Writer))
+
+	if c.Request.Method != http.MethodHead {
+		complete := c.Writer.Status() == http.StatusOK && int64(c.Writer.Size()) == info.Size
+		retention.Touch(key, complete)
+	}
+}
+
+// bodyServed reports whether the client got all of the file or range the response announced
+func bodyServed(w gin.ResponseWriter) bool {
+	if w.Status() != http.StatusOK && w.Status() != http.StatusPartialContent {
+		return false
+	}
+	length, err := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64)
+	return err == nil && int64(w.Size()) == length
+}
+
+func resolveJobKey(c *gin.Context, backend storage.Storage, requestID string) (string, error) {
+	if record := jobs.Lookup(requestID); record != nil && record.Result != nil && record.Result.StorageKey != "" {
+		return record.Result.StorageKey, nil
+	}
+
+	// Records are not always around, e.g. after the state directory was wiped
+	objects, err := backend.List(c.Request.Context(), requestID+"/")
+	if err != nil {
+		return "", err
+	}
+	for _, object := range objects {
+		if !strings.HasSuffix(object.Key, ".part") {
+			return object.Key, nil
+		}
+	}
+	return "", storage.ErrNotFound
+}
@@ -0,0 +1,227 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	util "backend/utils"
+)
+
+const testRequestID = "0123456789abcdef"
+
+// serveDownloads stores a 1000 byte file for testRequestID in a temporary
+// working directory, where the local storage backend looks for it
+func serveDownloads(t *testing.T) (*gin.Engine, []byte) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	content := []byte(strings.Repeat("0123456789", 100))
+	jobDir := filepath.Join(dir, "downloads", testRequestID)
+	if err := os.MkdirAll(jobDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(jobDir, "video.mp4"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/downloads/:id/:filename", DownloadFileHandler)
+	r.HEAD("/downloads/:id/:filename", DownloadFileHandler)
+	return r, content
+}
+
+// signedPath is the path and query of a signed link, without the public host
+func signedPath(name string, expires time.Time, once bool) string {
+	link, err := url.Parse(util.SignedDownloadURL(testRequestID, name, expires.Unix(), once))
+	if err != nil {
+		panic(err)
+	}
+	return link.RequestURI()
+}
+
+func serve(r *gin.Engine, method, target string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestDownloadFileHandlerSignatures(t *testing.T) {
+	r, content := serveDownloads(t)
+
+	valid := signedPath("My Video.mp4", time.Now().Add(time.Hour), false)
+	expired := signedPath("My Video.mp4", time.Now().Add(-time.Minute), false)
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+	}{
+		{"valid", valid, http.StatusOK},
+		{"expired", expired, http.StatusGone},
+		{"tampered signature", strings.Replace(valid, "sig=", "sig=00", 1), http.StatusForbidden},
+		{"other file name", strings.Replace(valid, "My%20Video.mp4", "Other.mp4", 1), http.StatusForbidden},
+		{"once flag added", valid + "&once=1", http.StatusForbidden},
+		{"missing signature", fmt.Sprintf("/downloads/%s/My%%20Video.mp4", testRequestID), http.StatusForbidden},
+		{"invalid request id", "/downloads/..%2F..%2Fetc/passwd", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(r, http.MethodGet, tt.target, nil)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d, body %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status == http.StatusOK && w.Body.String() != string(content) {
+				t.Fatalf("body has %d bytes, want the %d stored", w.Body.Len(), len(content))
+			}
+		})
+	}
+}
+
+func TestDownloadFileHandlerOnceLink(t *testing.T) {
+	r, _ := serveDownloads(t)
+	target := signedPath("video.mp4", time.Now().Add(time.Hour), true)
+
+	// HEAD doesn't use the link up
+	if w := serve(r, http.MethodHead, target, nil); w.Code != http.StatusOK {
+		t.Fatalf("HEAD status = %d, want 200", w.Code)
+	}
+	if w := serve(r, http.MethodGet, target, nil); w.Code != http.StatusOK {
+		t.Fatalf("first GET status = %d, want 200", w.Code)
+	}
+	if w := serve(r, http.MethodGet, target, nil); w.Code != http.StatusGone {
+		t.Fatalf("second GET status = %d, want 410", w.Code)
+	}
+}
+
+// cutWriter drops the connection after limit bytes of body
+type cutWriter struct {
+	*httptest.ResponseRecorder
+	limit int
+}
+
+func (w *cutWriter) Write(p []byte) (int, error) {
+	if w.Body.Len()+len(p) > w.limit {
+		n, _ := w.ResponseRecorder.Write(p[:w.limit-w.Body.Len()])
+		return n, errors.New("connection reset")
+	}
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestDownloadFileHandlerOnceLinkCutShort(t *testing.T) {
+	r, _ := serveDownloads(t)
+	// Spent links are remembered by signature, each test signs its own name
+	target := signedPath("cut.mp4", time.Now().Add(time.Hour), true)
+
+	cut := &cutWriter{ResponseRecorder: httptest.NewRecorder(), limit: 100}
+	r.ServeHTTP(cut, httptest.NewRequest(http.MethodGet, target, nil))
+	if cut.Body.Len() != 100 {
+		t.Fatalf("cut GET sent %d bytes, want 100", cut.Body.Len())
+	}
+
+	// The client didn't get the file, it may try again
+	if w := serve(r, http.MethodGet, target, nil); w.Code != http.StatusOK {
+		t.Fatalf("GET after a cut transfer: status = %d, want 200", w.Code)
+	}
+	if w := serve(r, http.MethodGet, target, nil); w.Code != http.StatusGone {
+		t.Fatalf("GET after a full transfer: status = %d, want 410", w.Code)
+	}
+}
+
+func TestDownloadFileHandlerOnceLinkRange(t *testing.T) {
+	r, _ := serveDownloads(t)
+	target := signedPath("range.mp4", time.Now().Add(time.Hour), true)
+
+	if w := serve(r, http.MethodGet, target, http.Header{"Range": {"bytes=0-99"}}); w.Code != http.StatusPartialContent {
+		t.Fatalf("range GET status = %d, want 206", w.Code)
+	}
+	if w := serve(r, http.MethodGet, target, http.Header{"Range": {"bytes=100-"}}); w.Code != http.StatusGone {
+		t.Fatalf("GET after a served range: status = %d, want 410", w.Code)
+	}
+}
+
+func TestDownloadFileHandlerRange(t *testing.T) {
+	r, content := serveDownloads(t)
+	target := signedPath("video.mp4", time.Now().Add(time.Hour), false)
+
+	w := serve(r, http.MethodGet, target, http.Header{"Range": {"bytes=0-99"}})
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("status = %d, want 206", w.Code)
+	}
+	if got, want := w.Header().Get("Content-Range"), fmt.Sprintf("bytes 0-99/%d", len(content)); got != want {
+		t.Fatalf("Content-Range = %q, want %q", got, want)
+	}
+	if w.Body.String() != string(content[:100]) {
+		t.Fatalf("body = %q, want the first 100 bytes", w.Body)
+	}
+
+	w = serve(r, http.MethodGet, target, http.Header{"Range": {"bytes=5000-"}})
+	if w.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("range past the end: status = %d, want 416", w.Code)
+	}
+}
+
+func TestDownloadFileHandlerConditional(t *testing.T) {
+	r, _ := serveDownloads(t)
+	target := signedPath("video.mp4", time.Now().Add(time.Hour), false)
+
+	first := serve(r, http.MethodGet, target, nil)
+	etag := first.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+
+	w := serve(r, http.MethodGet, target, http.Header{"If-None-Match": {etag}})
+	if w.Code != http.StatusNotModified {
+		t.Fatalf("status = %d, want 304", w.Code)
+	}
+	if w.Body.Len() != 0 {
+		t.Fatalf("304 has a body of %d bytes", w.Body.Len())
+	}
+}
+
+func TestDownloadFileHandlerHead(t *testing.T) {
+	r, content := serveDownloads(t)
+	target := signedPath("video.mp4", time.Now().Add(time.Hour), false)
+
+	w := serve(r, http.MethodHead, target, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if got := w.Header().Get("Content-Length"); got != fmt.Sprint(len(content)) {
+		t.Fatalf("Content-Length = %q, want %d", got, len(content))
+	}
+	if got := w.Header().Get("Content-Type"); got != "video/mp4" {
+		t.Fatalf("Content-Type = %q, want video/mp4", got)
+	}
+	if !strings.Contains(w.Header().Get("Content-Disposition"), "video.mp4") {
+		t.Fatalf("Content-Disposition = %q", w.Header().Get("Content-Disposition"))
+	}
+	if w.Body.Len() != 0 {
+		t.Fatalf("HEAD has a body of %d bytes", w.Body.Len())
+	}
+}
@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"backend/admission"
+	ytdlp "backend/yt-dlp"
+)
+
+// describeFailure is the error code, user facing message and retry hint of a failed job
+func describeFailure(err error) (code string, message string, retryable bool) {
+	var ytErr *ytdlp.Error
+
+	switch {
+	case errors.Is(err, admission.ErrQuotaExceeded):
+		return "quota_exceeded", "Storage quota exceeded. Delete or wait for older downloads to expire", false
+	case errors.Is(err, admission.ErrInsufficientSpace):
+		return "insufficient_space", "Server storage is full. Please try again later", true
+	case errors.As(err, &ytErr):
+		return string(ytErr.Code), ytErr.Message, ytErr.Retryable
+	default:
+		return string(ytdlp.CodeUnknown), "Download failed", true
+	}
+}
+
+// respondFailure answers a request that failed before a job was started,
+// fallback replaces the message when the cause is unknown
+func respondFailure(c *gin.Context, err error, fallback string) {
+	code, message, retryable := describeFailure(err)
+
+	status := http.StatusUnprocessableEntity
+	switch ytdlp.ErrorCode(code) {
+	case ytdlp.CodeUnknown:
+		status = http.StatusInternalServerError
+		message = fallback
+	case ytdlp.CodeUnsupportedURL:
+		status = http.StatusBadRequest
+	case ytdlp.CodeRateLimited, ytdlp.CodeNetwork:
+		status = http.StatusBadGateway
+	case ytdlp.CodeFFmpegMissing, ytdlp.CodeDiskFull:
+		status = http.StatusServiceUnavailable
+	case ytdlp.CodeTimeout:
+		status = http.StatusGatewayTimeout
+	}
+
+	c.JSON(status, gin.H{
+		"error":     message,
+		"code":      code,
+		"retryable": retryable,
+	})
+}
@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"backend/joblog"
+	"backend/jobs"
+)
+
+// StopJobHandler stops a running job, live recordings are finalized into a playable file
+func StopJobHandler(c *gin.Context) {
+	requestID := c.Param("id")
+
+	if !jobs.Stop(requestID) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Job not found or already finished",
+		})
+		return
+	}
+
+	log.Printf("[JOBS] Stop requested | RequestID=%s", requestID)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"request_id": requestID,
+		"status":     "stopping",
+	})
+}
+
+// JobLogsHandler returns the output of the tools a job ran, as plain text.
+// A deduplicated job shows the log of the download it was attached to.
+func JobLogsHandler(c *gin.Context) {
+	requestID := c.Param("id")
+	if record := jobs.Lookup(requestID); record != nil && record.AttachedTo != "" {
+		requestID = record.AttachedTo
+	}
+
+	data, err := joblog.Read(requestID)
+	if errors.Is(err, joblog.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "No log for this job",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("[JOBS] Failed to read log | RequestID=%s | Error=%v", requestID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to read job log",
+		})
+		return
+	}
+
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", data)
+}
@@ -1,12 +1,23 @@
 package controllers
 
 import (
+	"backend/admission"
+	"backend/config"
+	"backend/downloader"
+	"backend/jobs"
 	"backend/models"
+	"backend/retention"
 	"backend/services"
 	"backend/sse"
 	util "backend/utils"
+	ytdlp "backend/yt-dlp"
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"sort"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -40,7 +51,14 @@ func VideoHandler(c *gin.Context) {
 		sanitizedURL,
 	)
 
+	if err := downloader.CheckURL(c.Request.Context(), sanitizedURL); err != nil {
+		log.Printf("[VIDEO] Refused | RequestID=%s | Error=%v", requestID, err)
+		respondFailure(c, err, "This link is not supported")
+		return
+	}
+
 	videoInfo, err := services.GetVideoInfoService(
+		c.Request.Context(),
 		sanitizedURL,
 		string(platformInfo.VideoType),
 	)
@@ -48,9 +66,27 @@ func VideoHandler(c *gin.Context) {
 		log.Printf("[VIDEO] Metadata failed | RequestID=%s | Error=%v",
 			requestID, err)
 
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to fetch video info",
-		})
+		respondFailure(c, err, "Failed to fetch video info")
+		return
+	}
+
+	// yt-dlp knows whether a stream is running, a /live path may be a finished broadcast
+	switch {
+	case videoInfo.IsLive:
+		platformInfo.VideoType = models.VideoTypeLive
+	case videoInfo.LiveStatus != "" && platformInfo.VideoType == models.VideoTypeLive:
+		platformInfo.VideoType = models.VideoTypeVideo
+	}
+
+	// yt-dlp checks again with --match-filter, Iframely doesn't always report a duration
+	if maxDuration := config.Get().MaxDuration; maxDuration > 0 && !videoInfo.IsLive &&
+		req.ClipStart == "" && req.ClipEnd == "" &&
+		time.Duration(videoInfo.Duration*float64(time.Second)) > maxDuration {
+
+		err := fmt.Errorf("video is %.0fs long, the limit is %s", videoInfo.Duration, maxDuration)
+		log.Printf("[VIDEO] Rejected | RequestID=%s | Error=%v", requestID, err)
+
+		respondFailure(c, ytdlp.NewError(ytdlp.CodeTooLong, err), "Video is too long")
 		return
 	}
 
@@ -58,7 +94,7 @@ func VideoHandler(c *gin.Context) {
 		req,
 		requestID,
 		sanitizedURL,
-		videoInfo.Title,
+		videoInfo,
 		req.Quality,
 		platformInfo,
 	)
@@ -73,14 +109,12 @@ func startDownload(
 	req models.Request,
 	requestID string,
 	url string,
-	title string,
+	videoInfo *models.VideoInfo,
 	quality string,
 	platformInfo models.PlatformInfo,
-	
 ) {
-
 	log.Printf("[DOWNLOAD] Starting | RequestID=%s", requestID)
-	
+
 	videoQuality, status := util.CheckAndPickFormat(
 		quality,
 		string(platformInfo.VideoType),
@@ -94,52 +128,292 @@ func startDownload(
 		status,
 	)
 
-	if util.SlotsFull() {
-		sse.Send(requestID, gin.H{
-			"status":  "queued",
-			"message": "Too many downloads. Waiting for slot...",
-			"percent": 0,
-		})
-	}
-
-	util.AcquireSlot()
-	defer util.ReleaseSlot()
-
-	sse.Send(requestID, gin.H{
-		"status":  "start",
-		"message": "Download started",
-		"percent": 0,
-	})
+	isLive := platformInfo.VideoType == models.VideoTypeLive
 
 	downloadReq := models.DownloadVideoRequest{
 		OriginalReq:  req,
 		URL:          url,
 		RequestID:    requestID,
 		VideoQuality: videoQuality,
-		Title:        title,
+		Title:        videoInfo.Title,
 		Platform:     string(platformInfo.Platform),
 		VideoType:    string(platformInfo.VideoType),
+		IsLive:       isLive,
+		Duration:     videoInfo.Duration,
+	}
+
+	record := &jobs.Record{
+		ID:      requestID,
+		Request: downloadReq,
+		Status:  jobs.StatusQueued,
+	}
+
+	if !isLive {
+		primaryID, cached := services.ClaimDownload(downloadReq)
+
+		if cached != nil {
+			log.Printf("[DOWNLOAD] Reusing finished file | RequestID=%s | From=%s", requestID, primaryID)
+
+			record.AttachedTo = primaryID
+			saveRecord(record)
+			finishJob(requestID, jobs.StatusCompleted, cached, nil)
+			return
+		}
+
+		if primaryID != "" {
+			log.Printf("[DOWNLOAD] Attached to running download | RequestID=%s | Primary=%s", requestID, primaryID)
+
+			record.AttachedTo = primaryID
+			saveRecord(record)
+			attachFollower(primaryID, record)
+			return
+		}
+	}
+
+	saveRecord(record)
+	runJob(downloadReq)
+}
+
+func saveRecord(record *jobs.Record) {
+	if err := jobs.Save(record); err != nil {
+		log.Printf("[DOWNLOAD] Failed to persist job | RequestID=%s | Error=%v", record.ID, err)
+	}
+}
+
+// ResumeJobs re-queues every job that was queued or running when the
+// server stopped. yt-dlp continues from the .part files already on disk.
+func ResumeJobs() {
+	unfinished, err := jobs.LoadUnfinished()
+	if err != nil {
+		log.Printf("[DOWNLOAD] Failed to load unfinished jobs: %v", err)
+		return
+	}
+
+	// Primaries first so attached requests find them in the content cache
+	sort.SliceStable(unfinished, func(i, j int) bool {
+		return unfinished[i].AttachedTo == "" && unfinished[j].AttachedTo != ""
+	})
+
+	for _, record := range unfinished {
+		if record.AttachedTo != "" {
+			resumeAttached(record)
+			continue
+		}
+
+		// A live stream can't be continued where it was cut off
+		if record.Request.IsLive {
+			log.Printf("[DOWNLOAD] Not resuming live recording | RequestID=%s", record.ID)
+			jobs.SetStatus(record.ID, jobs.StatusFailed)
+			continue
+		}
+
+		// A job that keeps taking the server down is not worth another try
+		if maxResumes := config.Get().JobMaxResumes; record.Resumes >= maxResumes {
+			log.Printf("[DOWNLOAD] Not resuming, resumed %d times already | RequestID=%s", record.Resumes, record.ID)
+			finishJob(record.ID, jobs.StatusFailed, nil,
+				fmt.Errorf("download was interrupted by %d restarts", record.Resumes+1))
+			continue
+		}
+
+		log.Printf("[DOWNLOAD] Resuming | RequestID=%s | Resumes=%d", record.ID, record.Resumes)
+
+		jobs.Update(record.ID, func(r *jobs.Record) {
+			r.Status = jobs.StatusQueued
+			r.Resumes++
+		})
+
+		sse.Send(record.ID, gin.H{
+			"status":  "resuming",
+			"message": "Resuming download after restart",
+			"percent": 0,
+		})
+
+		if !record.Request.IsLive {
+			services.ClaimDownload(record.Request)
+		}
+
+		go runJob(record.Request)
+	}
+}
+
+func resumeAttached(record *jobs.Record) {
+	primary := jobs.Lookup(record.AttachedTo)
+	if primary != nil && primary.Finished() {
+		finishJob(record.ID, primary.Status, services.ShareResult(primary.Result, record.Request), nil)
+		return
+	}
+
+	primaryID, _ := services.ClaimDownload(record.Request)
+	if primaryID == "" {
+		// The primary is gone, this request now owns the download
+		jobs.Update(record.ID, func(r *jobs.Record) {
+			r.AttachedTo = ""
+		})
+		go runJob(record.Request)
+		return
+	}
+
+	attachFollower(primaryID, record)
+}
+
+func runJob(downloadReq models.DownloadVideoRequest) {
+	requestID := downloadReq.RequestID
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stop := cancel
+	if !downloadReq.IsLive {
+		services.SetDownloadCancel(downloadReq, cancel)
+
+		// Requests attached to this download keep it running
+		stop = func() {
+			if !services.StopDownload(downloadReq, requestID) {
+				cancel()
+				return
+			}
+			log.Printf("[DOWNLOAD] Stopped, download continues for attached requests | RequestID=%s", requestID)
+			finishJob(requestID, jobs.StatusStopped, nil, services.ErrStopped)
+			sse.Mute(requestID)
+		}
 	}
 
-	result, err := services.DownloadService(downloadReq)
+	jobs.Register(requestID, downloadReq.IsLive, stop)
+	defer jobs.Unregister(requestID)
+
+	notAdmitted := func(err error) {
+		log.Printf("[DOWNLOAD] Not admitted | RequestID=%s | Error=%v", requestID, err)
+
+		status := jobs.StatusFailed
+		if ctx.Err() != nil {
+			status = jobs.StatusStopped
+		}
+		if !downloadReq.IsLive {
+			finishAttached(downloadReq, status, nil, err)
+		}
+		finishJob(requestID, status, nil, err)
+	}
+
+	reservation, err := admission.Reserve(ctx, downloadReq, func(estimate int64, guessed bool) {
+		sse.Send(requestID, gin.H{
+			"status":           "queued",
+			"message":          "Waiting for storage space...",
+			"percent":          0,
+			"bytes":            estimate,
+			"duration_unknown": guessed,
+		})
+	})
 	if err != nil {
+		notAdmitted(err)
+		return
+	}
+	defer reservation.Release()
+
+	// Platform capacity and workers are taken stage by stage, see pipeline
+	result, err := services.DownloadService(ctx, downloadReq)
+
+	status := jobs.StatusCompleted
+	switch {
+	case errors.Is(err, services.ErrStopped):
+		log.Printf("[DOWNLOAD] Stopped | RequestID=%s", requestID)
+		status = jobs.StatusStopped
+	case err != nil:
 		log.Printf("[DOWNLOAD] Failed | RequestID=%s | Error=%v",
 			requestID, err)
+		status = jobs.StatusFailed
+		result = nil
+	default:
+		log.Printf("[DOWNLOAD] Completed | RequestID=%s", requestID)
+	}
 
+	if !downloadReq.IsLive {
+		finishAttached(downloadReq, status, result, err)
+	}
+
+	// Stopped while other requests were attached, its final state is stored
+	if record := jobs.Lookup(requestID); record != nil && record.Finished() {
+		return
+	}
+
+	finishJob(requestID, status, result, err)
+}
+
+// attachFollower mirrors the events of the running download primaryID onto
+// record. Stopping record only detaches it from the download.
+func attachFollower(primaryID string, record *jobs.Record) {
+	sse.Follow(primaryID, record.ID)
+
+	jobs.Register(record.ID, false, func() {
+		if !services.StopDownload(record.Request, record.ID) {
+			// The download is finishing, the request gets its result
+			return
+		}
+		jobs.Unregister(record.ID)
+		sse.Detach(primaryID, record.ID)
+
+		log.Printf("[DOWNLOAD] Detached from shared download | RequestID=%s | Primary=%s", record.ID, primaryID)
+		finishJob(record.ID, jobs.StatusStopped, nil, services.ErrStopped)
+	})
+}
+
+// finishJob stores the final state of a job and sends the last SSE event
+func finishJob(requestID string, status jobs.Status, result *models.VideoDownloadResult, err error) {
+	var request models.DownloadVideoRequest
+	jobs.Update(requestID, func(r *jobs.Record) {
+		r.Status = status
+		r.Result = result
+		request = r.Request
+	})
+
+	if status == jobs.StatusCompleted {
+		policy := retention.Policy("")
+		if request.OriginalReq.OneTimeLink {
+			policy = retention.PolicyAfterDownload
+		}
+		retention.Track(requestID, result, policy)
+	}
+
+	switch status {
+	case jobs.StatusStopped:
 		sse.Send(requestID, gin.H{
-			"status":  "error",
-			"message": "Download failed",
+			"status":  "stopped",
+			"message": "Download stopped",
 			"percent": 0,
 		})
-		return
+	case jobs.StatusFailed:
+		code, message, retryable := describeFailure(err)
+		sse.Send(requestID, gin.H{
+			"status":    "error",
+			"message":   message,
+			"code":      code,
+			"retryable": retryable,
+			"percent":   0,
+		})
+	default:
+		sse.Send(requestID, gin.H{
+			"status":  "completed",
+			"message": "Download complete",
+			"percent": 100,
+			"result":  result,
+		})
 	}
 
-	sse.Send(requestID, gin.H{
-		"status":  "completed",
-		"message": "Download complete",
-		"percent": 100,
-		"result":  result,
-	})
+	sse.Finish(requestID)
+}
 
-	log.Printf("[DOWNLOAD] Completed | RequestID=%s", requestID)
+// finishAttached hands the outcome of a shared download to every request
+// attached to it, each with its own download link
+func finishAttached(downloadReq models.DownloadVideoRequest, status jobs.Status, result *models.VideoDownloadResult, err error) {
+	followers := services.ReleaseDownload(downloadReq, result)
+	sse.Unfollow(downloadReq.RequestID)
+
+	for _, id := range followers {
+		jobs.Unregister(id)
+
+		record := jobs.Lookup(id)
+		if record == nil {
+			continue
+		}
+		finishJob(id, status, services.ShareResult(result, record.Request), err)
+	}
 }
@@ -0,0 +1,36 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHandlersRefusePrivateLinks(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/video", VideoHandler)
+	r.POST("/resolve", ResolveHandler)
+	r.POST("/stream-download", StreamDownloadHandler)
+
+	for _, path := range []string{"/video", "/resolve", "/stream-download"} {
+		for _, link := range []string{"http://127.0.0.1/video.mp4", "http://[::1]/admin", "http://192.168.1.1/"} {
+			req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"url":"`+link+`"}`))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			var body struct {
+				Code string `json:"code"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &body)
+			if w.Code != http.StatusBadRequest || body.Code != "unsupported_url" {
+				t.Errorf("POST %s %s: %d %s, want 400 unsupported_url", path, link, w.Code, w.Body)
+			}
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"backend/profiles"
+)
+
+// ListProfilesHandler lists the runner profiles in use by platform
+func ListProfilesHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"profiles": profiles.All(),
+	})
+}
+
+// ReloadProfilesHandler rereads RUNNER_PROFILES, a bad file keeps the current profiles
+func ReloadProfilesHandler(c *gin.Context) {
+	if err := profiles.Load(); err != nil {
+		log.Printf("[PROFILES] Reload failed: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"profiles": profiles.All(),
+	})
+}
@@ -0,0 +1,16 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"backend/proxy"
+)
+
+// ListProxiesHandler shows the proxy pool with health scores, passwords redacted
+func ListProxiesHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"proxies": proxy.List(),
+	})
+}
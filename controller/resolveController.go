@@ -0,0 +1,51 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"backend/downloader"
+	"backend/models"
+	"backend/services"
+	util "backend/utils"
+)
+
+// ResolveHandler returns the direct media URLs for clients that download from the CDN themselves
+func ResolveHandler(c *gin.Context) {
+	var req models.Request
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid JSON payload",
+		})
+		return
+	}
+
+	if req.URL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "URL is required",
+		})
+		return
+	}
+
+	req.URL = util.SanitizeURL(req.URL)
+
+	if err := downloader.CheckURL(c.Request.Context(), req.URL); err != nil {
+		log.Printf("[RESOLVE] Refused | URL=%s | Error=%v", req.URL, err)
+		respondFailure(c, err, "This link is not supported")
+		return
+	}
+
+	media, err := services.ResolveService(c.Request.Context(), req)
+	if err != nil {
+		log.Printf("[RESOLVE] Failed | URL=%s | Error=%v", req.URL, err)
+		respondFailure(c, err, "Failed to resolve media URLs")
+		return
+	}
+
+	log.Printf("[RESOLVE] URL=%s | Formats=%d | ExpiresAt=%d", req.URL, len(media.Formats), media.ExpiresAt)
+
+	c.JSON(http.StatusOK, media)
+}
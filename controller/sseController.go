@@ -24,7 +24,7 @@ func SSEHandler(c *gin.Context) {
 	c.Writer.Header().Set("Transfer-Encoding", "chunked")
 
 	client := sse.Register(requestID)
-	defer sse.Unregister(requestID)
+	defer sse.Unregister(requestID, client)
 
 	c.Stream(func(w io.Writer) bool {
 		if msg, ok := <-client.Channel; ok {
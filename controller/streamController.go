@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"backend/downloader"
+	"backend/models"
+	"backend/pipeline"
+	"backend/services"
+	util "backend/utils"
+)
+
+// StreamDownloadHandler pipes a short video straight into the response
+// without storing it, the download stops when the client goes away
+func StreamDownloadHandler(c *gin.Context) {
+	var req models.StreamVideoDownloadRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid JSON payload",
+		})
+		return
+	}
+
+	if req.URL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "URL is required",
+		})
+		return
+	}
+
+	req.URL = util.SanitizeURL(req.URL)
+
+	if err := downloader.CheckURL(c.Request.Context(), req.URL); err != nil {
+		log.Printf("[STREAM] Refused | URL=%s | Error=%v", req.URL, err)
+		respondFailure(c, err, "This link is not supported")
+		return
+	}
+
+	// A stream can't wait in a queue, the client is holding the connection
+	worker, ok := pipeline.TryAcquire(pipeline.Fetch)
+	if !ok {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Too many downloads, try again later",
+		})
+		return
+	}
+	defer worker.Release()
+
+	ctx := c.Request.Context()
+
+	media, err := services.ResolveStream(ctx, req)
+	switch {
+	case errors.Is(err, services.ErrStreamTooLong), errors.Is(err, services.ErrStreamLive):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": err.Error(),
+		})
+		return
+	case err != nil:
+		log.Printf("[STREAM] Resolve failed | URL=%s | Error=%v", req.URL, err)
+		respondFailure(c, err, "Failed to fetch video info")
+		return
+	}
+
+	ext, contentType := "mp4", "video/mp4"
+	if req.AudioOnly {
+		ext, contentType = "mp3", "audio/mpeg"
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", util.ContentDisposition(util.DisplayFileName(media.Title, ext)))
+	c.Header("Cache-Control", "no-store")
+	// Keep reverse proxies from buffering the whole file
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	log.Printf("[STREAM] Started | URL=%s | Title=%s", req.URL, media.Title)
+
+	err = services.StreamService(ctx, req, media, flushWriter{c.Writer})
+	switch {
+	case errors.Is(err, services.ErrStopped):
+		log.Printf("[STREAM] Client disconnected | URL=%s", req.URL)
+	case err != nil:
+		// Headers are out already, all we can do is cut the response short
+		log.Printf("[STREAM] Failed | URL=%s | Error=%v", req.URL, err)
+	default:
+		log.Printf("[STREAM] Completed | URL=%s", req.URL)
+	}
+}
+
+// flushWriter pushes every chunk to the client instead of waiting for the buffer to fill
+type flushWriter struct {
+	w gin.ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	f.w.Flush()
+	return n, err
+}
@@ -0,0 +1,53 @@
+package cookies
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+
+	"backend/config"
+)
+
+// seal encrypts a cookie file with AES-256-GCM, the nonce is prepended
+func seal(plaintext []byte) ([]byte, error) {
+	aead, err := newAEAD()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(ciphertext []byte) ([]byte, error) {
+	aead, err := newAEAD()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("cookie jar is truncated")
+	}
+	nonce, data := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+
+	plaintext, err := aead.Open(nil, nonce, data, nil)
+	if err != nil {
+		return nil, errors.New("cookie jar can't be decrypted, was COOKIE_ENCRYPTION_KEY changed?")
+	}
+	return plaintext, nil
+}
+
+func newAEAD() (cipher.AEAD, error) {
+	key := sha256.Sum256(config.Get().CookieEncryptionKey)
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
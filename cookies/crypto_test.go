@@ -0,0 +1,80 @@
+package cookies
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"backend/config"
+)
+
+func TestSealOpen(t *testing.T) {
+	plaintext := cookieFile(cookieLine(".youtube.com", 0, "SID"))
+
+	sealed, err := seal(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("youtube")) {
+		t.Fatal("sealed jar contains the plaintext")
+	}
+
+	// A fresh nonce every time
+	again, err := seal(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(sealed, again) {
+		t.Fatal("sealing twice gave the same bytes")
+	}
+
+	opened, err := open(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, plaintext) {
+		t.Fatalf("opened = %q, want %q", opened, plaintext)
+	}
+}
+
+func TestOpenRejects(t *testing.T) {
+	sealed, err := seal([]byte("cookies"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"truncated", sealed[:4], "truncated"},
+		{"tampered", tampered, "can't be decrypted"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := open(tt.data); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenWithOtherKey(t *testing.T) {
+	sealed, err := seal([]byte("cookies"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Get()
+	key := cfg.CookieEncryptionKey
+	cfg.CookieEncryptionKey = []byte("another key")
+	t.Cleanup(func() { cfg.CookieEncryptionKey = key })
+
+	if _, err := open(sealed); err == nil || !strings.Contains(err.Error(), "COOKIE_ENCRYPTION_KEY") {
+		t.Fatalf("err = %v, want it to point at COOKIE_ENCRYPTION_KEY", err)
+	}
+}
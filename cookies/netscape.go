@@ -0,0 +1,135 @@
+package cookies
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	util "backend/utils"
+)
+
+// Cookie is one line of a Netscape cookies.txt
+type Cookie struct {
+	Domain  string
+	Path    string
+	Secure  bool
+	Expires int64 // unix seconds, 0 for session cookies
+	Name    string
+	Value   string
+}
+
+// Parse reads a Netscape cookies.txt as written by browser extensions and yt-dlp
+func Parse(data []byte) ([]Cookie, error) {
+	var cookies []Cookie
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		// HttpOnly cookies are written as comments with this prefix
+		line = strings.TrimPrefix(line, "#HttpOnly_")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("line %d: expected 7 tab separated fields, got %d", n, len(fields))
+		}
+
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid expiry %q", n, fields[4])
+		}
+
+		cookies = append(cookies, Cookie{
+			Domain:  fields[0],
+			Path:    fields[2],
+			Secure:  strings.EqualFold(fields[3], "TRUE"),
+			Expires: expires,
+			Name:    fields[5],
+			Value:   fields[6],
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return cookies, nil
+}
+
+// Summary is what validation learned about a cookie file
+type Summary struct {
+	Cookies int // cookies for the platform's domains
+	// ExpiresAt is when the last persistent platform cookie expires, zero
+	// when there are only session cookies
+	ExpiresAt time.Time
+	Warnings  []string
+}
+
+// Validate checks that data is a cookie file usable for platform
+func Validate(platform string, data []byte) (*Summary, error) {
+	cookies, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cookie file: %w", err)
+	}
+	if len(cookies) == 0 {
+		return nil, errors.New("cookie file has no cookies")
+	}
+
+	domains := util.PlatformDomains(platform)
+	now := time.Now().Unix()
+	summary := &Summary{}
+
+	var expired, other int
+	for _, c := range cookies {
+		if !matchesDomain(c.Domain, domains) {
+			other++
+			continue
+		}
+		if c.Expires != 0 && c.Expires < now {
+			expired++
+			continue
+		}
+
+		summary.Cookies++
+		if c.Expires != 0 && c.Expires > summary.ExpiresAt.Unix() {
+			summary.ExpiresAt = time.Unix(c.Expires, 0)
+		}
+	}
+
+	if summary.Cookies == 0 {
+		if expired > 0 {
+			return nil, fmt.Errorf("all %d %s cookies are expired", expired, platform)
+		}
+		return nil, fmt.Errorf("no cookies for %s domains", platform)
+	}
+
+	if expired > 0 {
+		summary.Warnings = append(summary.Warnings, fmt.Sprintf("%d cookies are already expired", expired))
+	}
+	if other > 0 {
+		summary.Warnings = append(summary.Warnings, fmt.Sprintf("%d cookies belong to other sites", other))
+	}
+	if !summary.ExpiresAt.IsZero() && time.Until(summary.ExpiresAt) < expiryWarning {
+		summary.Warnings = append(summary.Warnings, "cookies expire on "+summary.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+
+	return summary, nil
+}
+
+func matchesDomain(domain string, domains []string) bool {
+	domain = strings.TrimPrefix(strings.ToLower(domain), ".")
+	for _, d := range domains {
+		if domain == d || strings.HasSuffix(domain, "."+d) || strings.HasSuffix(d, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package cookies
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cookieFile joins lines into a cookies.txt, fields are separated by tabs
+func cookieFile(lines ...string) []byte {
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+func cookieLine(domain string, expires int64, name string) string {
+	return fmt.Sprintf("%s\tTRUE\t/\tTRUE\t%d\t%s\tvalue", domain, expires, name)
+}
+
+func TestParse(t *testing.T) {
+	data := []byte("# Netscape HTTP Cookie File\r\n" +
+		"# This is a generated file! Do not edit.\r\n" +
+		"\r\n" +
+		".youtube.com\tTRUE\t/\tTRUE\t1893456000\tPREF\tf6=40000000\r\n" +
+		"#HttpOnly_.youtube.com\tTRUE\t/\tFALSE\t0\tVISITOR_INFO1_LIVE\tabc=def\r\n")
+
+	cookies, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Cookie{
+		{Domain: ".youtube.com", Path: "/", Secure: true, Expires: 1893456000, Name: "PREF", Value: "f6=40000000"},
+		{Domain: ".youtube.com", Path: "/", Secure: false, Expires: 0, Name: "VISITOR_INFO1_LIVE", Value: "abc=def"},
+	}
+	if len(cookies) != len(want) {
+		t.Fatalf("got %d cookies, want %d: %+v", len(cookies), len(want), cookies)
+	}
+	for i := range want {
+		if cookies[i] != want[i] {
+			t.Fatalf("cookie %d = %+v, want %+v", i, cookies[i], want[i])
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"spaces instead of tabs", []byte("# Netscape HTTP Cookie File\n.youtube.com TRUE / TRUE 0 PREF x\n"), "line 2: expected 7"},
+		{"missing value", []byte(".youtube.com\tTRUE\t/\tTRUE\t0\tPREF\n"), "line 1: expected 7"},
+		{"bad expiry", []byte(".youtube.com\tTRUE\t/\tTRUE\tnever\tPREF\tx\n"), `line 1: invalid expiry "never"`},
+		{"json export", []byte(`[{"domain": ".youtube.com", "name": "PREF"}]`), "line 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.data)
+			if err == nil {
+				t.Fatal("Parse accepted the file")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	later := time.Now().Add(30 * 24 * time.Hour).Unix()
+	soon := time.Now().Add(time.Hour).Unix()
+	past := time.Now().Add(-time.Hour).Unix()
+
+	tests := []struct {
+		name     string
+		data     []byte
+		cookies  int
+		warnings []string
+		err      string
+	}{
+		{
+			name:    "valid",
+			data:    cookieFile(cookieLine(".youtube.com", later, "SID"), cookieLine(".youtube.com", 0, "PREF")),
+			cookies: 2,
+		},
+		{
+			name:     "some expired and other sites",
+			data:     cookieFile(cookieLine(".youtube.com", later, "SID"), cookieLine(".youtube.com", past, "OLD"), cookieLine(".example.com", later, "X")),
+			cookies:  1,
+			warnings: []string{"1 cookies are already expired", "1 cookies belong to other sites"},
+		},
+		{
+			name:     "expiring soon",
+			data:     cookieFile(cookieLine("www.youtube.com", soon, "SID")),
+			cookies:  1,
+			warnings: []string{"cookies expire on"},
+		},
+		{
+			name: "all expired",
+			data: cookieFile(cookieLine(".youtube.com", past, "SID")),
+			err:  "all 1 YouTube cookies are expired",
+		},
+		{
+			name: "other platform",
+			data: cookieFile(cookieLine(".instagram.com", later, "sessionid")),
+			err:  "no cookies for YouTube domains",
+		},
+		{
+			name: "empty",
+			data: []byte("# Netscape HTTP Cookie File\n"),
+			err:  "no cookies",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summary, err := Validate("YouTube", tt.data)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if summary.Cookies != tt.cookies {
+				t.Fatalf("cookies = %d, want %d", summary.Cookies, tt.cookies)
+			}
+			if len(summary.Warnings) != len(tt.warnings) {
+				t.Fatalf("warnings = %q, want %q", summary.Warnings, tt.warnings)
+			}
+			for i, w := range tt.warnings {
+				if !strings.HasPrefix(summary.Warnings[i], w) {
+					t.Fatalf("warning %d = %q, want %q", i, summary.Warnings[i], w)
+				}
+			}
+		})
+	}
+}
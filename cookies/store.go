@@ -0,0 +1,419 @@
+package cookies
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	ytdlp "backend/yt-dlp"
+)
+
+const (
+	stateDir = "data/cookies"
+
+	// Jars expiring sooner than this are reported
+	expiryWarning = 72 * time.Hour
+
+	// A jar is taken out of rotation after this many login-required errors in a row
+	maxLoginFailures = 3
+)
+
+// ErrNotFound is returned for unknown jar ids
+var ErrNotFound = errors.New("cookie jar not found")
+
+// Jar is the metadata of a stored cookie file, the cookies themselves
+// are only kept encrypted in data/cookies/<id>.enc
+type Jar struct {
+	ID        string    `json:"id"`
+	Platform  string    `json:"platform"`
+	Cookies   int       `json:"cookies"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	LastUsed  time.Time `json:"last_used,omitempty"`
+	// LoginFailures counts login-required errors in a row, reset on success
+	LoginFailures int    `json:"login_failures"`
+	Disabled      bool   `json:"disabled"`
+	LastError     string `json:"last_error,omitempty"`
+}
+
+// Status is how the jar looks to an admin: active, expiring, expired or disabled
+func (j *Jar) Status() string {
+	switch {
+	case j.Disabled:
+		return "disabled"
+	case !j.ExpiresAt.IsZero() && time.Now().After(j.ExpiresAt):
+		return "expired"
+	case !j.ExpiresAt.IsZero() && time.Until(j.ExpiresAt) < expiryWarning:
+		return "expiring"
+	default:
+		return "active"
+	}
+}
+
+func (j *Jar) usable() bool {
+	status := j.Status()
+	return status == "active" || status == "expiring"
+}
+
+var (
+	jars = make(map[string]*Jar)
+	mu   sync.Mutex
+
+	// lastWarned keeps expiry warnings to one per jar per day
+	lastWarned = make(map[string]time.Time)
+)
+
+// Load reads the jar index written by earlier runs
+func Load() error {
+	data, err := os.ReadFile(filepath.Join(stateDir, "index.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read cookie jar index: %w", err)
+	}
+
+	var list []*Jar
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("failed to parse cookie jar index: %w", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, jar := range list {
+		jars[jar.ID] = jar
+	}
+
+	log.Printf("[COOKIES] Loaded %d cookie jars", len(list))
+	return nil
+}
+
+// Add validates and stores a cookie file for platform. Warnings are
+// problems that don't make the file unusable.
+func Add(platform string, data []byte) (*Jar, []string, error) {
+	summary, err := Validate(platform, data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	jar := &Jar{
+		ID:        newID(),
+		Platform:  platform,
+		Cookies:   summary.Cookies,
+		CreatedAt: time.Now(),
+		ExpiresAt: summary.ExpiresAt,
+	}
+
+	if err := writeJar(jar.ID, data); err != nil {
+		return nil, nil, err
+	}
+
+	mu.Lock()
+	jars[jar.ID] = jar
+	err = saveIndexLocked()
+	stored := *jar
+	mu.Unlock()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log.Printf("[COOKIES] Added jar | Platform=%s | ID=%s | Cookies=%d", platform, jar.ID, jar.Cookies)
+	return &stored, summary.Warnings, nil
+}
+
+// Remove deletes a jar and its encrypted file
+func Remove(id string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := jars[id]; !ok {
+		return ErrNotFound
+	}
+
+	delete(jars, id)
+	if err := os.Remove(jarPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	log.Printf("[COOKIES] Removed jar | ID=%s", id)
+	return saveIndexLocked()
+}
+
+// Get returns a copy of a jar
+func Get(id string) (*Jar, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	jar, ok := jars[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	stored := *jar
+	return &stored, nil
+}
+
+// List returns copies of the jars of platform, all jars when platform is ""
+func List(platform string) []Jar {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var list []Jar
+	for _, jar := range jars {
+		if platform == "" || jar.Platform == platform {
+			list = append(list, *jar)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Platform != list[j].Platform {
+			return list[i].Platform < list[j].Platform
+		}
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	return list
+}
+
+// Revalidate decrypts a jar and checks it again, a jar that passes is
+// enabled again with its failure count reset
+func Revalidate(id string) (*Jar, []string, error) {
+	jar, err := Get(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := readJar(id)
+	if err == nil {
+		var summary *Summary
+		summary, err = Validate(jar.Platform, data)
+		if err == nil {
+			update(id, func(j *Jar) {
+				j.Cookies = summary.Cookies
+				j.ExpiresAt = summary.ExpiresAt
+				j.LoginFailures = 0
+				j.Disabled = false
+				j.LastError = ""
+			})
+			jar, _ = Get(id)
+			return jar, summary.Warnings, nil
+		}
+	}
+
+	update(id, func(j *Jar) {
+		j.Disabled = true
+		j.LastError = err.Error()
+	})
+	return nil, nil, err
+}
+
+// Lease is a decrypted copy of a jar in a temporary file, handed to yt-dlp with --cookies
+type Lease struct {
+	Platform string
+	JarID    string // "" when the platform has no usable jar
+	Path     string
+
+	original []byte
+}
+
+// Acquire picks the least recently used jar of platform and writes it to a
+// temporary file. Without a usable jar the lease is empty, never nil.
+func Acquire(platform string) *Lease {
+	lease := &Lease{Platform: platform}
+
+	mu.Lock()
+	var picked *Jar
+	for _, jar := range jars {
+		if jar.Platform != platform || !jar.usable() {
+			continue
+		}
+		if picked == nil || jar.LastUsed.Before(picked.LastUsed) {
+			picked = jar
+		}
+	}
+	var id string
+	if picked != nil {
+		picked.LastUsed = time.Now()
+		warnExpiryLocked(picked)
+		id = picked.ID
+	}
+	mu.Unlock()
+
+	if id == "" {
+		return lease
+	}
+
+	data, err := readJar(id)
+	if err != nil {
+		log.Printf("[COOKIES] Jar unusable | Platform=%s | ID=%s | Error=%v", platform, id, err)
+		update(id, func(j *Jar) {
+			j.Disabled = true
+			j.LastError = err.Error()
+		})
+		return lease
+	}
+
+	f, err := os.CreateTemp("", "cookies-*.txt")
+	if err != nil {
+		log.Printf("[COOKIES] Failed to create temp file: %v", err)
+		return lease
+	}
+	_, err = f.Write(data)
+	f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		log.Printf("[COOKIES] Failed to write temp file: %v", err)
+		return lease
+	}
+
+	lease.JarID = id
+	lease.Path = f.Name()
+	lease.original = data
+	return lease
+}
+
+// Release records how the run went and removes the temporary file.
+// Cookies yt-dlp refreshed during a successful run are stored back.
+func (l *Lease) Release(runErr error) {
+	if l == nil {
+		return
+	}
+
+	if l.Path != "" {
+		defer os.Remove(l.Path)
+	}
+
+	if LoginRequired(runErr) {
+		if l.JarID == "" {
+			log.Printf("[COOKIES] WARNING %s requires login and has no usable cookie jar", l.Platform)
+			return
+		}
+
+		update(l.JarID, func(j *Jar) {
+			j.LoginFailures++
+			j.LastError = runErr.Error()
+			if j.LoginFailures >= maxLoginFailures {
+				j.Disabled = true
+			}
+			log.Printf("[COOKIES] WARNING %s returned login required | ID=%s | Failures=%d | Disabled=%v",
+				l.Platform, l.JarID, j.LoginFailures, j.Disabled)
+		})
+		return
+	}
+
+	if l.JarID == "" || runErr != nil {
+		return
+	}
+
+	update(l.JarID, func(j *Jar) {
+		j.LoginFailures = 0
+	})
+
+	data, err := os.ReadFile(l.Path)
+	if err != nil || bytes.Equal(data, l.original) {
+		return
+	}
+	summary, err := Validate(l.Platform, data)
+	if err != nil {
+		return
+	}
+	if err := writeJar(l.JarID, data); err != nil {
+		log.Printf("[COOKIES] Failed to store refreshed cookies | ID=%s | Error=%v", l.JarID, err)
+		return
+	}
+	update(l.JarID, func(j *Jar) {
+		j.Cookies = summary.Cookies
+		j.ExpiresAt = summary.ExpiresAt
+	})
+}
+
+// LoginRequired reports whether a yt-dlp error asks for cookies
+func LoginRequired(err error) bool {
+	code := ytdlp.CodeOf(err)
+	return code == ytdlp.CodeLoginRequired || code == ytdlp.CodeAgeRestricted
+}
+
+func warnExpiryLocked(jar *Jar) {
+	if jar.Status() != "expiring" || time.Since(lastWarned[jar.ID]) < 24*time.Hour {
+		return
+	}
+	lastWarned[jar.ID] = time.Now()
+	log.Printf("[COOKIES] WARNING jar expires soon | Platform=%s | ID=%s | ExpiresAt=%s",
+		jar.Platform, jar.ID, jar.ExpiresAt.UTC().Format(time.RFC3339))
+}
+
+func update(id string, fn func(jar *Jar)) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	jar, ok := jars[id]
+	if !ok {
+		return
+	}
+	fn(jar)
+
+	if err := saveIndexLocked(); err != nil {
+		log.Printf("[COOKIES] Failed to save jar index: %v", err)
+	}
+}
+
+func saveIndexLocked() error {
+	list := make([]*Jar, 0, len(jars))
+	for _, jar := range jars {
+		list = append(list, jar)
+	}
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(stateDir, "index.json"), data)
+}
+
+func readJar(id string) ([]byte, error) {
+	data, err := os.ReadFile(jarPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cookie jar: %w", err)
+	}
+	return open(data)
+}
+
+func writeJar(id string, plaintext []byte) error {
+	data, err := seal(plaintext)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt cookie jar: %w", err)
+	}
+	return writeFile(jarPath(id), data)
+}
+
+// writeFile writes then renames so a crash never leaves half a file
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return fmt.Errorf("failed to create cookie directory: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func jarPath(id string) string {
+	return filepath.Join(stateDir, id+".enc")
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
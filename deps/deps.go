@@ -0,0 +1,209 @@
+// Package deps finds the external tools the server runs, checks they work
+// and keeps a managed copy of yt-dlp that admins can update
+package deps
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/config"
+	"backend/proc"
+)
+
+const (
+	YTDLP     = "yt-dlp"
+	FFmpeg    = "ffmpeg"
+	FFprobe   = "ffprobe"
+	GalleryDL = "gallery-dl"
+)
+
+// tool is a binary the server can run, required ones make /health report degraded
+type tool struct {
+	name        string
+	versionFlag string
+	required    bool
+}
+
+var tools = []tool{
+	{YTDLP, "--version", true},
+	{FFmpeg, "-version", true},
+	{FFprobe, "-version", false},
+	{GalleryDL, "--version", false},
+}
+
+// Status is what the last check found out about a tool
+type Status struct {
+	Available bool      `json:"available"`
+	Required  bool      `json:"required"`
+	Managed   bool      `json:"managed"` // running the copy in BIN_DIR
+	Path      string    `json:"path,omitempty"`
+	Version   string    `json:"version,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+var (
+	statuses = make(map[string]Status)
+	mu       sync.Mutex
+)
+
+// Path resolves name to the binary to run: the managed copy in BIN_DIR
+// when there is one, the one on PATH otherwise
+func Path(name string) (string, error) {
+	managed := filepath.Join(config.Get().BinDir, name)
+	if info, err := os.Stat(managed); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+		return filepath.Abs(managed)
+	}
+
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", fmt.Errorf("%s binary not found: %w", name, err)
+	}
+	return path, nil
+}
+
+// checkInterval is how often Start runs the tools again, so /health
+// notices a binary that broke or was fixed after startup
+const checkInterval = time.Minute
+
+// Check runs every tool once for its version, missing required tools are
+// logged loudly. Only changes are logged, the first check logs everything.
+func Check(ctx context.Context) {
+	for _, t := range tools {
+		previous, seen := Statuses()[t.name]
+		status := check(ctx, t)
+		if seen && previous.Available == status.Available && previous.Version == status.Version && previous.Path == status.Path {
+			continue
+		}
+
+		switch {
+		case status.Available:
+			log.Printf("[DEPS] %s %s | Path=%s | Managed=%v", t.name, status.Version, status.Path, status.Managed)
+		case t.required:
+			log.Printf("[DEPS] ERROR %s is not usable, downloads will fail: %s", t.name, status.Error)
+		default:
+			log.Printf("[DEPS] %s not available: %s", t.name, status.Error)
+		}
+	}
+}
+
+// Start checks the tools again every minute until ctx is done
+func Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				Check(ctx)
+			}
+		}
+	}()
+}
+
+// Versions returns the version of every tool that worked at the last check
+func Versions() map[string]string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	versions := make(map[string]string, len(statuses))
+	for name, status := range statuses {
+		if status.Available {
+			versions[name] = status.Version
+		}
+	}
+	return versions
+}
+
+// Healthy reports whether every required tool worked at the last check
+func Healthy() bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, t := range tools {
+		if t.required && !statuses[t.name].Available {
+			return false
+		}
+	}
+	return true
+}
+
+// Statuses returns the result of the last check of every tool
+func Statuses() map[string]Status {
+	mu.Lock()
+	defer mu.Unlock()
+
+	list := make(map[string]Status, len(statuses))
+	for name, status := range statuses {
+		list[name] = status
+	}
+	return list
+}
+
+func check(ctx context.Context, t tool) Status {
+	status := Status{Required: t.required, CheckedAt: time.Now()}
+
+	path, err := Path(t.name)
+	if err == nil {
+		status.Path = path
+		status.Managed = strings.HasPrefix(path, managedDir())
+		status.Version, err = version(ctx, path, t.versionFlag)
+	}
+	if err != nil {
+		status.Error = err.Error()
+	} else {
+		status.Available = true
+	}
+
+	mu.Lock()
+	statuses[t.name] = status
+	mu.Unlock()
+
+	return status
+}
+
+// version runs path with flag and returns the version from its first line,
+// "ffmpeg version 6.1.1 Copyright ..." becomes "6.1.1"
+func version(ctx context.Context, path, flag string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := proc.CommandContext(ctx, proc.Options{Timeout: 15 * time.Second}, path, flag)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s %s failed: %w | stderr: %s", filepath.Base(path), flag, err, strings.TrimSpace(stderr.String()))
+	}
+
+	line, _, _ := strings.Cut(strings.TrimSpace(stdout.String()), "\n")
+	if line == "" {
+		return "", errors.New("no version printed")
+	}
+
+	fields := strings.Fields(line)
+	for i, field := range fields {
+		if field == "version" && i+1 < len(fields) {
+			return fields[i+1], nil
+		}
+	}
+	return fields[len(fields)-1], nil
+}
+
+func managedDir() string {
+	dir, err := filepath.Abs(config.Get().BinDir)
+	if err != nil {
+		return config.Get().BinDir
+	}
+	return dir + string(filepath.Separator)
+}
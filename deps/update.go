@@ -0,0 +1,224 @@
+package deps
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/config"
+)
+
+// Anything bigger is not a yt-dlp release
+const maxBinarySize = 256 << 20
+
+var (
+	ErrUpdateRunning = errors.New("an update is already running")
+	ErrNoRollback    = errors.New("there is no managed yt-dlp to roll back")
+)
+
+// updateMu keeps updates and rollbacks from overlapping
+var updateMu sync.Mutex
+
+// UpdateResult is the yt-dlp version before and after an update or rollback
+type UpdateResult struct {
+	Previous string `json:"previous"`
+	Version  string `json:"version"`
+	Path     string `json:"path"`
+}
+
+// UpdateYTDLP downloads the release at YTDLP_UPDATE_URL into BIN_DIR, checks
+// it against the release checksums and that it runs, then swaps it in with a
+// rename. The replaced copy is kept as yt-dlp.prev for RollbackYTDLP and put
+// back right away when the new one fails its check. Running downloads keep
+// the binary they started with.
+func UpdateYTDLP(ctx context.Context) (*UpdateResult, error) {
+	if !updateMu.TryLock() {
+		return nil, ErrUpdateRunning
+	}
+	defer updateMu.Unlock()
+
+	cfg := config.Get()
+	if err := os.MkdirAll(cfg.BinDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create binary directory: %w", err)
+	}
+
+	result := &UpdateResult{Previous: Statuses()[YTDLP].Version}
+
+	managed := filepath.Join(cfg.BinDir, YTDLP)
+	download := managed + ".download"
+	defer os.Remove(download)
+
+	sum, err := fetch(ctx, cfg.YTDLPUpdateURL, download)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.YTDLPChecksumURL != "" {
+		if err := verifyChecksum(ctx, cfg.YTDLPChecksumURL, path.Base(cfg.YTDLPUpdateURL), sum); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.Chmod(download, 0755); err != nil {
+		return nil, err
+	}
+	if _, err := version(ctx, download, "--version"); err != nil {
+		return nil, fmt.Errorf("downloaded yt-dlp doesn't run: %w", err)
+	}
+
+	// The current copy stays reachable under its name until the rename replaces it
+	hadManaged := false
+	if _, err := os.Stat(managed); err == nil {
+		hadManaged = true
+		os.Remove(managed + ".prev")
+		if err := os.Link(managed, managed+".prev"); err != nil {
+			return nil, fmt.Errorf("failed to keep the current yt-dlp: %w", err)
+		}
+	}
+
+	if err := os.Rename(download, managed); err != nil {
+		return nil, fmt.Errorf("failed to swap in the new yt-dlp: %w", err)
+	}
+
+	status := check(ctx, tools[0])
+	if !status.Available || !status.Managed {
+		log.Printf("[DEPS] New yt-dlp failed its check, rolling back: %s", status.Error)
+		if hadManaged {
+			os.Rename(managed+".prev", managed)
+		} else {
+			os.Remove(managed)
+		}
+		check(ctx, tools[0])
+		return nil, fmt.Errorf("new yt-dlp failed its check: %s", status.Error)
+	}
+
+	result.Version = status.Version
+	result.Path = status.Path
+
+	log.Printf("[DEPS] Updated yt-dlp | Previous=%s | Version=%s", result.Previous, result.Version)
+	return result, nil
+}
+
+// RollbackYTDLP puts back the copy the last update replaced. Without one the
+// managed copy is removed and the yt-dlp on PATH is used again.
+func RollbackYTDLP(ctx context.Context) (*UpdateResult, error) {
+	if !updateMu.TryLock() {
+		return nil, ErrUpdateRunning
+	}
+	defer updateMu.Unlock()
+
+	result := &UpdateResult{Previous: Statuses()[YTDLP].Version}
+
+	managed := filepath.Join(config.Get().BinDir, YTDLP)
+	switch {
+	case fileExists(managed + ".prev"):
+		if err := os.Rename(managed+".prev", managed); err != nil {
+			return nil, fmt.Errorf("failed to restore the previous yt-dlp: %w", err)
+		}
+	case fileExists(managed):
+		if err := os.Remove(managed); err != nil {
+			return nil, fmt.Errorf("failed to remove the managed yt-dlp: %w", err)
+		}
+	default:
+		return nil, ErrNoRollback
+	}
+
+	status := check(ctx, tools[0])
+	result.Version = status.Version
+	result.Path = status.Path
+
+	log.Printf("[DEPS] Rolled back yt-dlp | Previous=%s | Version=%s | Path=%s",
+		result.Previous, result.Version, result.Path)
+	return result, nil
+}
+
+// fetch downloads rawURL to dest and returns its SHA-256
+func fetch(ctx context.Context, rawURL, dest string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	resp, err := get(ctx, rawURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	f, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, hash), io.LimitReader(resp.Body, maxBinarySize+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to download yt-dlp: %w", err)
+	}
+	if n > maxBinarySize {
+		return "", fmt.Errorf("yt-dlp download is larger than %d bytes", maxBinarySize)
+	}
+
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// verifyChecksum looks name up in a sha256sum style file
+func verifyChecksum(ctx context.Context, sumsURL, name, sum string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	resp, err := get(ctx, sumsURL)
+	if err != nil {
+		return fmt.Errorf("failed to fetch checksums: %w", err)
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(io.LimitReader(resp.Body, 1<<20))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || strings.TrimPrefix(fields[1], "*") != name {
+			continue
+		}
+		if !strings.EqualFold(fields[0], sum) {
+			return fmt.Errorf("checksum mismatch for %s: got %s, want %s", name, sum, fields[0])
+		}
+		return nil
+	}
+	return fmt.Errorf("no checksum for %s", name)
+}
+
+func get(ctx context.Context, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	return resp, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
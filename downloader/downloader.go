@@ -0,0 +1,130 @@
+// Package downloader fetches media with the tool that suits the link best:
+// yt-dlp for video platforms, plain HTTP for direct media files and
+// gallery-dl for image galleries. Every backend sends the same progress events.
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"backend/config"
+	"backend/models"
+	util "backend/utils"
+	ytdlp "backend/yt-dlp"
+)
+
+const (
+	YTDLP     = "yt-dlp"
+	HTTP      = "http"
+	GalleryDL = "gallery-dl"
+)
+
+// ErrLive is returned for a link fetched as a video that turns out to be a
+// running live stream, it has to be recorded instead
+var ErrLive = errors.New("link is a running live stream")
+
+// Job is one download, the backend writes into Dir and names its file after Name
+type Job struct {
+	Request models.DownloadVideoRequest
+	Dir     string
+	Name    string // file name without extension
+	Session ytdlp.Session
+}
+
+// Downloader is a backend that can fetch a job
+type Downloader interface {
+	Name() string
+	// Download returns the path of the file it wrote, nil when the backend
+	// can't tell and the job directory has to be searched. Several paths are
+	// the video and audio streams of one file, still to be merged.
+	Download(ctx context.Context, job Job) ([]string, error)
+}
+
+var backends = map[string]Downloader{
+	YTDLP:     ytdlpDownloader{},
+	HTTP:      httpDownloader{},
+	GalleryDL: galleryDownloader{},
+}
+
+var (
+	// platforms maps a platform to the backend DOWNLOADERS assigned to it
+	platforms = make(map[string]string)
+	mu        sync.Mutex
+)
+
+// Setup reads DOWNLOADERS, e.g. "Pinterest=gallery-dl,Imgur=gallery-dl"
+func Setup(cfg *config.Config) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	platforms = make(map[string]string)
+
+	for _, entry := range cfg.Downloaders {
+		name, backend, ok := strings.Cut(entry, "=")
+		if !ok {
+			return fmt.Errorf("invalid downloader %q, expected platform=backend", entry)
+		}
+
+		platform, ok := util.CanonicalPlatform(strings.TrimSpace(name))
+		if !ok {
+			return fmt.Errorf("unknown platform %q in downloaders", name)
+		}
+
+		backend = strings.TrimSpace(backend)
+		if _, ok := backends[backend]; !ok {
+			return fmt.Errorf("unknown downloader %q for %s", backend, platform)
+		}
+
+		platforms[platform] = backend
+	}
+
+	if len(platforms) > 0 {
+		log.Printf("[DOWNLOADER] Platform backends | %v", platforms)
+	}
+	return nil
+}
+
+// Get returns the backend called name
+func Get(name string) (Downloader, bool) {
+	d, ok := backends[name]
+	return d, ok
+}
+
+// Select picks the backend for req: the one configured for its platform,
+// plain HTTP when the link is a media file, yt-dlp otherwise. proxy is
+// used to sniff the link.
+func Select(ctx context.Context, req models.DownloadVideoRequest, proxy string) Downloader {
+	mu.Lock()
+	backend, ok := platforms[req.Platform]
+	mu.Unlock()
+	if ok {
+		return backends[backend]
+	}
+
+	// Platform pages are HTML, only links to unknown sites can be files
+	if req.Platform != "" && req.Platform != "Unknown" {
+		return backends[YTDLP]
+	}
+
+	// Clips and audio extraction need yt-dlp's post-processing
+	clip := req.OriginalReq.ClipStart != "" || req.OriginalReq.ClipEnd != ""
+
+	switch sniff(ctx, req.URL, proxy) {
+	case kindVideo:
+		if !clip && !req.OriginalReq.AudioOnly {
+			return backends[HTTP]
+		}
+	case kindAudio:
+		if !clip {
+			return backends[HTTP]
+		}
+	case kindImage:
+		return backends[HTTP]
+	}
+
+	return backends[YTDLP]
+}
@@ -0,0 +1,179 @@
+package downloader
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/config"
+	"backend/deps"
+	"backend/joblog"
+	"backend/proc"
+	"backend/sse"
+	ytdlp "backend/yt-dlp"
+)
+
+// galleryDownloader runs gallery-dl for image galleries and platforms yt-dlp
+// handles badly. A gallery of several files is delivered as one zip.
+type galleryDownloader struct{}
+
+func (galleryDownloader) Name() string {
+	return GalleryDL
+}
+
+func (galleryDownloader) Download(ctx context.Context, job Job) ([]string, error) {
+	binary, err := deps.Path(deps.GalleryDL)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := config.Get()
+
+	// gallery-dl skips files that already exist, a resumed job continues
+	galleryDir := filepath.Join(job.Dir, job.Name+".gallery")
+
+	args := []string{"--directory", galleryDir}
+	switch {
+	case job.Session.CookiesFile != "":
+		args = append(args, "--cookies", job.Session.CookiesFile)
+	case job.Session.CookiesBrowser != "":
+		args = append(args, "--cookies-from-browser", job.Session.CookiesBrowser)
+	}
+	if job.Session.Proxy != "" {
+		args = append(args, "--proxy", job.Session.Proxy)
+	}
+	if cfg.MaxFileSize > 0 {
+		args = append(args, "--filesize-max", strconv.FormatInt(cfg.MaxFileSize, 10))
+	}
+	args = append(args, "--", job.Request.URL)
+
+	cmd := proc.CommandContext(ctx, proc.Options{Timeout: cfg.DownloadTimeout, Graceful: true}, binary, args...)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("stdout pipe error: %w", err)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("gallery-dl start failed: %w", err)
+	}
+
+	// gallery-dl prints the path of every file, "# " marks skipped ones
+	var files int
+	var lastSent time.Time
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		joblog.Printf(ctx, "%s", line)
+
+		files++
+		if time.Since(lastSent) >= time.Second {
+			sse.Send(job.Request.RequestID, map[string]interface{}{
+				"status":  "downloading",
+				"message": fmt.Sprintf("Downloaded %d files", files),
+				"percent": 0,
+				"files":   files,
+			})
+			lastSent = time.Now()
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return nil, ytdlp.Classify(err, stderr.String())
+	}
+
+	paths, err := galleryFiles(galleryDir)
+	if err != nil || len(paths) == 0 {
+		return nil, ytdlp.Classify(errors.New("gallery-dl downloaded no files"), stderr.String())
+	}
+
+	log.Printf("[DOWNLOADER] gallery-dl | RequestID=%s | Files=%d", job.Request.RequestID, len(paths))
+
+	if len(paths) == 1 {
+		output := filepath.Join(job.Dir, job.Name+filepath.Ext(paths[0]))
+		if err := os.Rename(paths[0], output); err != nil {
+			return nil, fmt.Errorf("failed to move download into place: %w", err)
+		}
+		os.RemoveAll(galleryDir)
+		return []string{output}, nil
+	}
+
+	output := filepath.Join(job.Dir, job.Name+".zip")
+	if err := zipFiles(output, galleryDir, paths); err != nil {
+		os.Remove(output)
+		return nil, fmt.Errorf("failed to zip gallery: %w", err)
+	}
+	os.RemoveAll(galleryDir)
+	return []string{output}, nil
+}
+
+// galleryFiles lists the finished files under dir in name order
+func galleryFiles(dir string) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(path) == ".part" {
+			return nil
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	sort.Strings(paths)
+	return paths, err
+}
+
+// zipFiles stores paths into a zip at output, named relative to root. Media
+// is already compressed, so the files are stored as they are.
+func zipFiles(output, root string, paths []string) error {
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := zip.NewWriter(f)
+	for _, path := range paths {
+		name, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		entry, err := w.CreateHeader(&zip.FileHeader{Name: filepath.ToSlash(name), Method: zip.Store})
+		if err != nil {
+			return err
+		}
+
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(entry, src)
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
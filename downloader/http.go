@@ -0,0 +1,592 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"backend/config"
+	"backend/fragments"
+	"backend/joblog"
+	"backend/jobs"
+	util "backend/utils"
+	ytdlp "backend/yt-dlp"
+)
+
+const (
+	kindVideo = "video"
+	kindAudio = "audio"
+	kindImage = "image"
+)
+
+// Files below this are fetched over one connection
+const minChunkedSize = 16 << 20
+
+var mediaExtensions = map[string]string{
+	".mp4": kindVideo, ".m4v": kindVideo, ".mov": kindVideo, ".webm": kindVideo, ".mkv": kindVideo,
+	".mp3": kindAudio, ".m4a": kindAudio, ".aac": kindAudio, ".ogg": kindAudio, ".opus": kindAudio, ".wav": kindAudio, ".flac": kindAudio,
+	".jpg": kindImage, ".jpeg": kindImage, ".png": kindImage, ".gif": kindImage, ".webp": kindImage,
+}
+
+var contentTypeExtensions = map[string]string{
+	"video/mp4": ".mp4", "video/quicktime": ".mov", "video/webm": ".webm", "video/x-matroska": ".mkv",
+	"audio/mpeg": ".mp3", "audio/mp4": ".m4a", "audio/aac": ".aac", "audio/ogg": ".ogg", "audio/opus": ".opus",
+	"audio/wav": ".wav", "audio/x-wav": ".wav", "audio/flac": ".flac",
+	"image/jpeg": ".jpg", "image/png": ".png", "image/gif": ".gif", "image/webp": ".webp",
+}
+
+// sniff tells whether rawURL is a media file, by its extension or else by
+// the Content-Type of a HEAD request. "" means a page yt-dlp has to extract.
+func sniff(ctx context.Context, rawURL, proxy string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	if kind, ok := mediaExtensions[strings.ToLower(path.Ext(u.Path))]; ok {
+		return kind
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, rawURL, nil)
+	if err != nil {
+		return ""
+	}
+	resp, err := newClient(proxy, ytdlp.Profile{}).Do(req)
+	if err != nil {
+		return ""
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return ""
+	}
+	kind, _, _ := strings.Cut(resp.Header.Get("Content-Type"), "/")
+	switch kind {
+	case kindVideo, kindAudio, kindImage:
+		return kind
+	}
+	return ""
+}
+
+// httpDownloader fetches direct media links, large files over several
+// ranged connections. Progress is kept next to the .part file so a resumed
+// job continues where it stopped.
+type httpDownloader struct{}
+
+func (httpDownloader) Name() string {
+	return HTTP
+}
+
+// remoteFile is what a ranged GET for the first byte tells about a file
+type remoteFile struct {
+	size   int64 // 0 when unknown
+	ranges bool
+	ext    string
+}
+
+// chunkState is the resume file, <output>.part.json
+type chunkState struct {
+	Size   int64   `json:"size"`
+	Chunks []chunk `json:"chunks"`
+}
+
+type chunk struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"` // inclusive
+	Done  int64 `json:"done"`
+}
+
+func (httpDownloader) Download(ctx context.Context, job Job) ([]string, error) {
+	parent := ctx
+	cfg := config.Get()
+
+	if cfg.DownloadTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.DownloadTimeout)
+		defer cancel()
+	}
+
+	client := newClient(job.Session.Proxy, job.Session.Profile)
+
+	file, err := inspect(ctx, client, job.Request.URL)
+	if err != nil {
+		return nil, httpFailure(parent, err)
+	}
+
+	if cfg.MaxFileSize > 0 && file.size > cfg.MaxFileSize {
+		return nil, ytdlp.NewError(ytdlp.CodeTooLarge,
+			fmt.Errorf("file is %d bytes, the limit is %d", file.size, cfg.MaxFileSize))
+	}
+
+	output := filepath.Join(job.Dir, job.Name+file.ext)
+	part := output + ".part"
+	progress := newProgress(job.Request.RequestID)
+
+	// The connections count against FRAGMENT_BUDGET like yt-dlp's fragments
+	chunked := file.ranges && file.size >= minChunkedSize
+	wanted := 1
+	if chunked {
+		wanted = max(cfg.DirectConnections, 1)
+	}
+	lease := fragments.Acquire(job.Request.Platform, wanted)
+	tuning := lease.Tuning
+	joblog.Printf(ctx, "connections %d of %d | Reason=%s | ActiveJobs=%d",
+		tuning.Fragments, tuning.Wanted, tuning.Reason, tuning.ActiveJobs)
+
+	log.Printf("[DOWNLOADER] HTTP | RequestID=%s | Size=%d | Ranges=%v | Connections=%d | Output=%s",
+		job.Request.RequestID, file.size, file.ranges, tuning.Fragments, output)
+
+	if chunked {
+		err = fetchChunks(ctx, client, job.Request.URL, part, file.size, tuning.Fragments, progress)
+	} else {
+		err = fetchStream(ctx, client, job.Request.URL, part, file, cfg.MaxFileSize, progress)
+	}
+
+	fetched, elapsed := progress.transfer()
+	if err != nil {
+		fetched = 0
+	}
+	lease.Done(fetched, elapsed)
+
+	tuning = lease.Tuning
+	jobs.Update(job.Request.RequestID, func(r *jobs.Record) {
+		r.Fragments = &tuning
+	})
+
+	if err != nil {
+		return nil, httpFailure(parent, err)
+	}
+
+	if err := os.Rename(part, output); err != nil {
+		return nil, fmt.Errorf("failed to move download into place: %w", err)
+	}
+	os.Remove(part + ".json")
+
+	progress.report(1, 1)
+	return []string{output}, nil
+}
+
+// newClient sends requests through proxy with the headers and user agent of
+// profile. Links users send can't reach the server's own network.
+func newClient(proxy string, profile ytdlp.Profile) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	var base http.RoundTripper = transport
+	if u, err := url.Parse(proxy); proxy != "" && err == nil {
+		// The proxy resolves the host, the name is checked before instead
+		transport.Proxy = http.ProxyURL(u)
+		base = publicTransport{transport}
+	} else {
+		// Checked on the address actually dialed, a second DNS answer can't
+		// sneak in between check and connect
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: refusePrivate}
+		transport.DialContext = dialer.DialContext
+	}
+	return &http.Client{Transport: profileTransport{base, profile}}
+}
+
+// refusePrivate is the net.Dialer Control that keeps direct connections off
+// loopback, link-local and private (RFC 1918, RFC 4193) addresses
+func refusePrivate(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return ytdlp.NewError(ytdlp.CodeUnsupportedURL,
+			fmt.Errorf("refusing to connect to the private address %s", host))
+	}
+	return nil
+}
+
+// publicTransport refuses hosts that resolve to private addresses before
+// a proxy connects to them. Every redirect passes through it.
+type publicTransport struct {
+	base http.RoundTripper
+}
+
+func (t publicTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := checkHost(req.Context(), req.URL.Hostname()); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+// CheckURL refuses links to the server's own network before anything
+// fetches them, yt-dlp included: a non-HTTP scheme, a private address or a
+// host that resolves to one. Hosts of known platforms are not looked up.
+func CheckURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return ytdlp.NewError(ytdlp.CodeUnsupportedURL, fmt.Errorf("not an http(s) link: %q", rawURL))
+	}
+
+	if net.ParseIP(u.Hostname()) == nil && util.DetectPlatform(rawURL).Platform != "Unknown" {
+		return nil
+	}
+	return checkHost(ctx, u.Hostname())
+}
+
+// checkHost refuses host when it is or resolves to a private address
+func checkHost(ctx context.Context, host string) error {
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return ytdlp.NewError(ytdlp.CodeNetwork, err)
+		}
+		ips = ips[:0]
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+
+	for _, ip := range ips {
+		if !publicIP(ip) {
+			return ytdlp.NewError(ytdlp.CodeUnsupportedURL,
+				fmt.Errorf("%s resolves to the private address %s", host, ip))
+		}
+	}
+	return nil
+}
+
+func publicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast()
+}
+
+type profileTransport struct {
+	base    http.RoundTripper
+	profile ytdlp.Profile
+}
+
+func (t profileTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(t.profile.Headers) == 0 && t.profile.UserAgent == "" {
+		return t.base.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	for name, value := range t.profile.Headers {
+		req.Header.Set(name, value)
+	}
+	if t.profile.UserAgent != "" {
+		req.Header.Set("User-Agent", t.profile.UserAgent)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// inspect asks for the first byte, a 206 tells the size and that ranges work
+func inspect(ctx context.Context, client *http.Client, rawURL string) (*remoteFile, error) {
+	resp, err := get(ctx, client, rawURL, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	file := &remoteFile{ext: extension(rawURL, resp.Header.Get("Content-Type"))}
+
+	if resp.StatusCode == http.StatusPartialContent {
+		// Content-Range: bytes 0-0/12345
+		_, total, _ := strings.Cut(resp.Header.Get("Content-Range"), "/")
+		if size, err := strconv.ParseInt(total, 10, 64); err == nil {
+			file.size = size
+			file.ranges = true
+		}
+	} else if resp.ContentLength > 0 {
+		file.size = resp.ContentLength
+	}
+
+	return file, nil
+}
+
+// get requests rawURL from offset to end (inclusive), end -1 for the rest of the file
+func get(ctx context.Context, client *http.Client, rawURL string, offset, end int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case end >= 0:
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, end))
+	case offset > 0:
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, ytdlp.Classify(fmt.Errorf("unexpected status %s", resp.Status),
+			fmt.Sprintf("HTTP Error %d: %s", resp.StatusCode, http.StatusText(resp.StatusCode)))
+	}
+	return resp, nil
+}
+
+// fetchStream downloads over one connection, appending to what an earlier run left when the server allows it.
+// A file of unknown size is cut off once it grows past maxSize, 0 for no limit.
+func fetchStream(ctx context.Context, client *http.Client, rawURL, part string, file *remoteFile, maxSize int64, progress *progress) error {
+	var offset int64
+	if info, err := os.Stat(part); err == nil && file.ranges && info.Size() < file.size {
+		offset = info.Size()
+	}
+
+	resp, err := get(ctx, client, rawURL, offset, -1)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if offset > 0 && resp.StatusCode == http.StatusPartialContent {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	} else {
+		offset = 0
+	}
+
+	f, err := os.OpenFile(part, flags, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	done := offset
+	buf := make([]byte, 256<<10)
+	for {
+		n, readErr := resp.Body.Read(buf)
+		if n > 0 {
+			if _, err := f.Write(buf[:n]); err != nil {
+				return err
+			}
+			done += int64(n)
+			progress.add(int64(n))
+			progress.report(done, file.size)
+
+			if maxSize > 0 && done > maxSize {
+				f.Close()
+				os.Remove(part)
+				return ytdlp.NewError(ytdlp.CodeTooLarge,
+					fmt.Errorf("file passed %d bytes, the limit is %d", done, maxSize))
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+
+	if file.size > 0 && done != file.size {
+		return fmt.Errorf("download ended at %d of %d bytes: %w", done, file.size, io.ErrUnexpectedEOF)
+	}
+	return nil
+}
+
+// fetchChunks downloads size bytes over connections ranged requests at once.
+// A run resumed with fewer connections than chunks fetches them in turns.
+func fetchChunks(ctx context.Context, client *http.Client, rawURL, part string, size int64, connections int, progress *progress) error {
+	state := loadState(part, size)
+	if state == nil {
+		state = &chunkState{Size: size}
+		step := size / int64(connections)
+		for i := 0; i < connections; i++ {
+			c := chunk{Start: int64(i) * step, End: int64(i+1)*step - 1}
+			if i == connections-1 {
+				c.End = size - 1
+			}
+			state.Chunks = append(state.Chunks, c)
+		}
+	}
+
+	f, err := os.OpenFile(part, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := f.Truncate(size); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+		lastSave time.Time
+	)
+
+	// Called with mu held
+	completed := func() int64 {
+		var done int64
+		for _, c := range state.Chunks {
+			done += c.Done
+		}
+		return done
+	}
+
+	slots := make(chan struct{}, connections)
+
+	for i := range state.Chunks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			var err error
+			select {
+			case slots <- struct{}{}:
+				err = fetchChunk(ctx, client, rawURL, f, state.Chunks[i], func(n int64) {
+					mu.Lock()
+					defer mu.Unlock()
+
+					state.Chunks[i].Done += n
+					progress.add(n)
+					progress.report(completed(), size)
+
+					if time.Since(lastSave) > 2*time.Second {
+						saveState(part, state)
+						lastSave = time.Now()
+					}
+				})
+				<-slots
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Kept on failure too, the next attempt continues from here
+	saveState(part, state)
+	return firstErr
+}
+
+func fetchChunk(ctx context.Context, client *http.Client, rawURL string, f *os.File, c chunk, wrote func(n int64)) error {
+	offset := c.Start + c.Done
+	if offset > c.End {
+		return nil
+	}
+
+	resp, err := get(ctx, client, rawURL, offset, c.End)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("server ignored the range request for bytes %d-%d", offset, c.End)
+	}
+
+	buf := make([]byte, 256<<10)
+	for offset <= c.End {
+		n, readErr := resp.Body.Read(buf)
+		if n > 0 {
+			n = int(min(int64(n), c.End-offset+1))
+			if _, err := f.WriteAt(buf[:n], offset); err != nil {
+				return err
+			}
+			offset += int64(n)
+			wrote(int64(n))
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+
+	if offset <= c.End {
+		return fmt.Errorf("chunk ended at %d of %d: %w", offset, c.End, io.ErrUnexpectedEOF)
+	}
+	return nil
+}
+
+// loadState returns the progress an earlier run saved, nil when there is none for this size
+func loadState(part string, size int64) *chunkState {
+	data, err := os.ReadFile(part + ".json")
+	if err != nil {
+		return nil
+	}
+
+	var state chunkState
+	if err := json.Unmarshal(data, &state); err != nil || state.Size != size || len(state.Chunks) == 0 {
+		return nil
+	}
+	if _, err := os.Stat(part); err != nil {
+		return nil
+	}
+	return &state
+}
+
+func saveState(part string, state *chunkState) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(part+".json", data, 0644); err != nil {
+		log.Printf("[DOWNLOADER] Failed to save progress of %s: %v", part, err)
+	}
+}
+
+// extension of the downloaded file, from the URL or else the Content-Type
+func extension(rawURL, contentType string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		ext := strings.ToLower(path.Ext(u.Path))
+		if _, ok := mediaExtensions[ext]; ok {
+			return ext
+		}
+	}
+
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	if ext, ok := contentTypeExtensions[strings.TrimSpace(strings.ToLower(mediaType))]; ok {
+		return ext
+	}
+	return ".bin"
+}
+
+// httpFailure classifies a failed fetch, a stop through the caller's ctx is passed through
+func httpFailure(parent context.Context, err error) error {
+	var ytErr *ytdlp.Error
+	switch {
+	case parent.Err() != nil:
+		return err
+	case errors.As(err, &ytErr):
+		return err
+	case errors.Is(err, context.DeadlineExceeded):
+		return ytdlp.NewError(ytdlp.CodeTimeout, err)
+	default:
+		// Connection resets, timeouts and a full disk read the same as from yt-dlp
+		return ytdlp.Classify(err, err.Error())
+	}
+}
@@ -0,0 +1,248 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ytdlp "backend/yt-dlp"
+)
+
+func TestClientRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("request reached the private server: %s", r.URL)
+	}))
+	t.Cleanup(server.Close)
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/video.mp4", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = newClient("", ytdlp.Profile{}).Do(req)
+	if code := ytdlp.CodeOf(err); code != ytdlp.CodeUnsupportedURL {
+		t.Fatalf("GET %s: err = %v, code %q, want %q", server.URL, err, code, ytdlp.CodeUnsupportedURL)
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want ytdlp.ErrorCode // "" for allowed
+	}{
+		{"https://www.youtube.com/watch?v=abc", ""},
+		{"https://93.184.216.34/video.mp4", ""},
+		{"http://127.0.0.1/video.mp4", ytdlp.CodeUnsupportedURL},
+		{"http://[::1]:8080/video.mp4", ytdlp.CodeUnsupportedURL},
+		{"http://10.0.0.5/video.mp4", ytdlp.CodeUnsupportedURL},
+		{"http://169.254.169.254/latest/meta-data/", ytdlp.CodeUnsupportedURL},
+		{"http://localhost/video.mp4", ytdlp.CodeUnsupportedURL},
+		{"file:///etc/passwd", ytdlp.CodeUnsupportedURL},
+		{"ftp://example.com/video.mp4", ytdlp.CodeUnsupportedURL},
+	}
+
+	for _, tt := range tests {
+		if got := ytdlp.CodeOf(CheckURL(context.Background(), tt.url)); got != tt.want {
+			t.Errorf("CheckURL(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+// rangeServer serves content with Range support, with cut > 0 every
+// response is cut off after cut bytes of body
+type rangeServer struct {
+	*httptest.Server
+	content []byte
+
+	mu     sync.Mutex
+	ranges []string
+}
+
+func newRangeServer(t *testing.T, size int, cut int64) *rangeServer {
+	t.Helper()
+
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(i * 7)
+	}
+
+	s := &rangeServer{content: content}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.ranges = append(s.ranges, r.Header.Get("Range"))
+		s.mu.Unlock()
+
+		var out http.ResponseWriter = w
+		if cut > 0 {
+			out = &cutWriter{ResponseWriter: w, left: cut}
+		}
+		http.ServeContent(out, r, "video.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// requested returns the Range headers received since the last call
+func (s *rangeServer) requested() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ranges := s.ranges
+	s.ranges = nil
+	slices.Sort(ranges)
+	return ranges
+}
+
+// cutWriter drops the connection after left bytes of body
+type cutWriter struct {
+	http.ResponseWriter
+	left int64
+}
+
+func (w *cutWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > w.left {
+		n, _ := w.ResponseWriter.Write(p[:w.left])
+		w.left = 0
+		return n, errors.New("connection cut")
+	}
+	w.left -= int64(len(p))
+	return w.ResponseWriter.Write(p)
+}
+
+func readState(t *testing.T, part string) *chunkState {
+	t.Helper()
+
+	data, err := os.ReadFile(part + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state chunkState
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	return &state
+}
+
+func TestFetchChunks(t *testing.T) {
+	server := newRangeServer(t, 1<<20, 0)
+	part := filepath.Join(t.TempDir(), "video.mp4.part")
+	progress := newProgress("0123456789abcdef")
+
+	err := fetchChunks(context.Background(), server.Client(), server.URL, part, int64(len(server.content)), 4, progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(part)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, server.content) {
+		t.Fatal("downloaded file differs from the served one")
+	}
+
+	want := []string{"bytes=0-262143", "bytes=262144-524287", "bytes=524288-786431", "bytes=786432-1048575"}
+	if ranges := server.requested(); !slices.Equal(ranges, want) {
+		t.Fatalf("ranges = %q, want %q", ranges, want)
+	}
+
+	if fetched, _ := progress.transfer(); fetched != int64(len(server.content)) {
+		t.Fatalf("fetched = %d, want %d", fetched, len(server.content))
+	}
+}
+
+func TestFetchChunksResume(t *testing.T) {
+	const size = 1 << 20
+	part := filepath.Join(t.TempDir(), "video.mp4.part")
+	ctx := context.Background()
+
+	// The first run loses its connections part way
+	cutting := newRangeServer(t, size, 100_000)
+	if err := fetchChunks(ctx, cutting.Client(), cutting.URL, part, size, 4, newProgress("0123456789abcdef")); err == nil {
+		t.Fatal("cut run succeeded")
+	}
+
+	saved := readState(t, part)
+	var done int64
+	var want []string
+	for _, c := range saved.Chunks {
+		if c.Done < 0 || c.Done > c.End-c.Start+1 {
+			t.Fatalf("chunk %+v has an impossible progress", c)
+		}
+		done += c.Done
+		if c.Start+c.Done <= c.End {
+			want = append(want, fmt.Sprintf("bytes=%d-%d", c.Start+c.Done, c.End))
+		}
+	}
+	if saved.Size != size || len(saved.Chunks) != 4 || done == 0 || done == size {
+		t.Fatalf("saved state %+v, %d bytes done", saved, done)
+	}
+	slices.Sort(want)
+
+	// The next run, with fewer connections, only asks for what is missing
+	server := newRangeServer(t, size, 0)
+	progress := newProgress("0123456789abcdef")
+	if err := fetchChunks(ctx, server.Client(), server.URL, part, size, 2, progress); err != nil {
+		t.Fatal(err)
+	}
+
+	if ranges := server.requested(); !slices.Equal(ranges, want) {
+		t.Fatalf("resumed ranges = %q, want %q", ranges, want)
+	}
+	if fetched, _ := progress.transfer(); fetched != size-done {
+		t.Fatalf("resumed run fetched %d bytes, want the %d missing", fetched, size-done)
+	}
+
+	got, err := os.ReadFile(part)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, server.content) {
+		t.Fatal("resumed file differs from the served one")
+	}
+	for _, c := range readState(t, part).Chunks {
+		if c.Start+c.Done != c.End+1 {
+			t.Fatalf("chunk %+v not complete", c)
+		}
+	}
+}
+
+func TestLoadStateIgnoresStaleProgress(t *testing.T) {
+	dir := t.TempDir()
+	part := filepath.Join(dir, "video.mp4.part")
+	saveState(part, &chunkState{Size: 1000, Chunks: []chunk{{Start: 0, End: 999, Done: 500}}})
+
+	// The .part file itself is gone
+	if state := loadState(part, 1000); state != nil {
+		t.Fatalf("loadState without a part file = %+v", state)
+	}
+
+	if err := os.WriteFile(part, make([]byte, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if state := loadState(part, 1000); state == nil || state.Chunks[0].Done != 500 {
+		t.Fatalf("loadState = %+v, want the saved progress", state)
+	}
+
+	// The file changed size on the server since
+	if state := loadState(part, 2000); state != nil {
+		t.Fatalf("loadState for another size = %+v", state)
+	}
+
+	if err := os.WriteFile(part+".json", []byte(strings.Repeat("{", 3)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if state := loadState(part, 1000); state != nil {
+		t.Fatalf("loadState of a corrupt file = %+v", state)
+	}
+}
@@ -0,0 +1,69 @@
+package downloader
+
+import (
+	"sync"
+	"time"
+
+	"backend/sse"
+)
+
+// progress sends the "downloading" events the yt-dlp runner sends, at most one a second
+type progress struct {
+	requestID string
+
+	mu       sync.Mutex
+	lastSent time.Time
+	percent  float64
+
+	// What this run fetched, bytes an earlier run left don't count
+	fetched     int64
+	first, last time.Time
+}
+
+func newProgress(requestID string) *progress {
+	return &progress{requestID: requestID}
+}
+
+// report is safe to call from several goroutines, total 0 means unknown
+func (p *progress) report(done, total int64) {
+	if total <= 0 {
+		return
+	}
+	percent := float64(done) * 100 / float64(total)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if percent <= p.percent || (time.Since(p.lastSent) < time.Second && percent < 100) {
+		return
+	}
+
+	sse.Send(p.requestID, map[string]interface{}{
+		"status":  "downloading",
+		"message": "Downloading",
+		"percent": percent,
+	})
+	p.lastSent = time.Now()
+	p.percent = percent
+}
+
+// add counts n bytes this run fetched
+func (p *progress) add(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if p.first.IsZero() {
+		p.first = now
+	}
+	p.last = now
+	p.fetched += n
+}
+
+// transfer returns the bytes this run fetched and the time from the first to the last
+func (p *progress) transfer() (int64, time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.fetched, p.last.Sub(p.first)
+}
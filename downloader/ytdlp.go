@@ -0,0 +1,126 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"backend/config"
+	"backend/fragments"
+	"backend/joblog"
+	"backend/jobs"
+	"backend/models"
+	"backend/ratelimit"
+	util "backend/utils"
+	ytdlp "backend/yt-dlp"
+)
+
+// ytdlpDownloader is the default backend, it knows every platform
+type ytdlpDownloader struct{}
+
+func (ytdlpDownloader) Name() string {
+	return YTDLP
+}
+
+func (ytdlpDownloader) Download(ctx context.Context, job Job) ([]string, error) {
+	// yt-dlp picks the extension, webm or mkv when formats can't go into mp4
+	output := filepath.Join(job.Dir, job.Name+".%(ext)s")
+
+	// The MP3 conversion runs on a merge worker, see services.extractAudio
+	format := "bestaudio"
+	wanted := 4
+	if !job.Request.OriginalReq.AudioOnly {
+		// yt-dlp would merge video and audio while the job holds its fetch
+		// worker. The streams the chain picks are fetched one by one instead,
+		// services.mergeStreams joins them on a merge worker.
+		// A run of its own, it takes a request token like metadata lookups
+		if err := ratelimit.Wait(ctx, job.Request.Platform, nil); err != nil {
+			return nil, err
+		}
+		media, err := ytdlp.ResolveFormats(ctx, job.Request.URL, job.Request.VideoQuality, job.Session)
+		if err != nil {
+			return nil, err
+		}
+		if media.IsLive && !job.Request.IsLive {
+			return nil, ErrLive
+		}
+		format = formatList(media.Formats)
+		if len(media.Formats) > 1 {
+			output = filepath.Join(job.Dir, job.Name+".f%(format_id)s.%(ext)s")
+		}
+
+		// The requested quality, VideoQuality is a format chain down to 144p
+		wanted = util.GetFragmentsByQuality(job.Request.OriginalReq.Quality)
+	}
+
+	lease := fragments.Acquire(job.Request.Platform, wanted)
+	tuning := lease.Tuning
+	joblog.Printf(ctx, "fragments %d of %d | Reason=%s | ActiveJobs=%d | PlatformSpeed=%d",
+		tuning.Fragments, tuning.Wanted, tuning.Reason, tuning.ActiveJobs, tuning.PlatformSpeed)
+
+	args, err := buildYTArgs(job.Request, format, output, job.Session, tuning.Fragments)
+	if err != nil {
+		lease.Done(0, 0)
+		return nil, err
+	}
+
+	paths, transfer, err := ytdlp.RunYTDownloadWithProgress(ctx, args, job.Request.RequestID, config.Get().DownloadTimeout)
+	if err != nil {
+		transfer = ytdlp.Transfer{}
+	}
+	lease.Done(transfer.Bytes, transfer.Elapsed)
+
+	tuning = lease.Tuning
+	jobs.Update(job.Request.RequestID, func(r *jobs.Record) {
+		r.Fragments = &tuning
+	})
+	if tuning.Speed > 0 {
+		joblog.Printf(ctx, "fetched %d bytes in %s at %d bytes/s", transfer.Bytes, transfer.Elapsed.Round(time.Second), tuning.Speed)
+	}
+
+	return paths, err
+}
+
+// formatList selects exactly the resolved formats, "137,140" downloads
+// both into files of their own where "137+140" would merge them
+func formatList(formats []models.ResolvedFormat) string {
+	ids := make([]string, len(formats))
+	for i, f := range formats {
+		ids[i] = f.FormatID
+	}
+	return strings.Join(ids, ",")
+}
+
+func buildYTArgs(
+	request models.DownloadVideoRequest,
+	format string,
+	outputPath string,
+	session ytdlp.Session,
+	concurrentFragments int,
+) ([]string, error) {
+
+	cmd := ytdlp.NewCommand(request.URL).
+		Session(session).
+		Progress().
+		Continue().
+		Output(outputPath).
+		PrintFinalPath().
+		Format(format).
+		ConcurrentFragments(concurrentFragments)
+
+	cfg := config.Get()
+	if cfg.MaxFileSize > 0 {
+		cmd.MaxFileSize(cfg.MaxFileSize)
+	}
+
+	if start, end := request.OriginalReq.ClipStart, request.OriginalReq.ClipEnd; start != "" || end != "" {
+		cmd.Section(start, end)
+	} else if cfg.MaxDuration > 0 {
+		// "?" lets videos without a known duration through
+		cmd.MatchFilter(fmt.Sprintf("duration<=?%d", int(cfg.MaxDuration.Seconds())))
+	}
+
+	return cmd.Build()
+}
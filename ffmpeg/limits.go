@@ -0,0 +1,11 @@
+package ffmpeg
+
+import (
+	"backend/config"
+	"backend/proc"
+)
+
+// limits are the process limits of every ffmpeg and ffprobe run
+func limits() proc.Options {
+	return proc.Options{Timeout: config.Get().FFmpegTimeout}
+}
@@ -0,0 +1,103 @@
+package ffmpeg
+
+import (
+	"backend/deps"
+	"backend/models"
+	"backend/proc"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Probe reads resolution, frame rate, codecs and bitrate of a media file with ffprobe
+func Probe(ctx context.Context, path string) (*models.MediaProbe, error) {
+	binary, err := deps.Path(deps.FFprobe)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := proc.CommandContext(
+		ctx,
+		limits(),
+		binary,
+		"-v", "error",
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+		path,
+	)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffprobe failed: %w | stderr: %s", err, stderr.String())
+	}
+
+	// ffprobe prints most numbers as strings
+	var data struct {
+		Streams []struct {
+			CodecType    string `json:"codec_type"`
+			CodecName    string `json:"codec_name"`
+			Width        int    `json:"width"`
+			Height       int    `json:"height"`
+			AvgFrameRate string `json:"avg_frame_rate"`
+			Disposition  struct {
+				AttachedPic int `json:"attached_pic"`
+			} `json:"disposition"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+			Size     string `json:"size"`
+			BitRate  string `json:"bit_rate"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &data); err != nil {
+		return nil, fmt.Errorf("ffprobe parse error: %w", err)
+	}
+
+	probe := &models.MediaProbe{}
+	probe.Duration, _ = strconv.ParseFloat(data.Format.Duration, 64)
+	probe.FileSize, _ = strconv.ParseInt(data.Format.Size, 10, 64)
+	probe.Bitrate, _ = strconv.ParseInt(data.Format.BitRate, 10, 64)
+
+	for _, s := range data.Streams {
+		switch s.CodecType {
+		case "video":
+			// Embedded thumbnails show up as video streams too
+			if s.Disposition.AttachedPic == 1 || probe.VideoCodec != "" {
+				continue
+			}
+			probe.VideoCodec = s.CodecName
+			probe.Width = s.Width
+			probe.Height = s.Height
+			probe.FPS = parseRate(s.AvgFrameRate)
+		case "audio":
+			if probe.AudioCodec == "" {
+				probe.AudioCodec = s.CodecName
+			}
+		}
+	}
+
+	return probe, nil
+}
+
+// parseRate turns ffprobe's "30000/1001" into frames per second
+func parseRate(rate string) float64 {
+	num, den, ok := strings.Cut(rate, "/")
+	if !ok {
+		v, _ := strconv.ParseFloat(rate, 64)
+		return v
+	}
+
+	n, err1 := strconv.ParseFloat(num, 64)
+	d, err2 := strconv.ParseFloat(den, 64)
+	if err1 != nil || err2 != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
@@ -0,0 +1,110 @@
+package ffmpeg
+
+import (
+	"backend/deps"
+	"backend/proc"
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// RemuxToMP4 copies the streams of input into a faststart MP4 without re-encoding
+func RemuxToMP4(ctx context.Context, input, output string) error {
+	binary, err := deps.Path(deps.FFmpeg)
+	if err != nil {
+		return err
+	}
+
+	cmd := proc.CommandContext(
+		ctx,
+		limits(),
+		binary,
+		"-y",
+		"-hide_banner",
+		"-loglevel", "error",
+		"-i", input,
+		"-map", "0",
+		"-c", "copy",
+		"-movflags", "+faststart",
+		output,
+	)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg remux failed: %w | stderr: %s", err, stderr.String())
+	}
+
+	return nil
+}
+
+// Merge copies the streams of every input, video first, into a faststart
+// MP4 without re-encoding, what yt-dlp's --merge-output-format mp4 does
+func Merge(ctx context.Context, inputs []string, output string) error {
+	binary, err := deps.Path(deps.FFmpeg)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"-y", "-hide_banner", "-loglevel", "error"}
+	for _, input := range inputs {
+		args = append(args, "-i", input)
+	}
+	for i := range inputs {
+		args = append(args, "-map", strconv.Itoa(i))
+	}
+	args = append(args,
+		"-c", "copy",
+		"-movflags", "+faststart",
+		output,
+	)
+
+	cmd := proc.CommandContext(ctx, limits(), binary, args...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg merge failed: %w | stderr: %s", err, stderr.String())
+	}
+
+	return nil
+}
+
+// mp3Quality is the libmp3lame VBR quality of every MP3 we write, the
+// default of yt-dlp's --extract-audio
+const mp3Quality = "5"
+
+// ExtractAudio converts the audio of input to an MP3 at output
+func ExtractAudio(ctx context.Context, input, output string) error {
+	binary, err := deps.Path(deps.FFmpeg)
+	if err != nil {
+		return err
+	}
+
+	cmd := proc.CommandContext(
+		ctx,
+		limits(),
+		binary,
+		"-y",
+		"-hide_banner",
+		"-loglevel", "error",
+		"-i", input,
+		"-vn",
+		"-map", "0:a:0",
+		"-c:a", "libmp3lame",
+		"-q:a", mp3Quality,
+		output,
+	)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg audio extraction failed: %w | stderr: %s", err, stderr.String())
+	}
+
+	return nil
+}
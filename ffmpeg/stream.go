@@ -0,0 +1,65 @@
+package ffmpeg
+
+import (
+	"backend/deps"
+	"backend/proc"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// StreamMedia muxes the streams read from inputs into w without touching
+// the disk, ffmpeg never fetches anything itself. Video goes out as
+// fragmented MP4, which players can start before the end of the file is
+// known; audio only is converted to MP3 like regular downloads.
+func StreamMedia(ctx context.Context, inputs []*os.File, audioOnly bool, w io.Writer) error {
+	binary, err := deps.Path(deps.FFmpeg)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"-hide_banner", "-loglevel", "error", "-nostdin"}
+
+	// ExtraFiles start at descriptor 3
+	for i := range inputs {
+		args = append(args, "-i", fmt.Sprintf("pipe:%d", 3+i))
+	}
+
+	if audioOnly {
+		args = append(args,
+			"-map", "0:a:0",
+			"-c:a", "libmp3lame",
+			"-q:a", mp3Quality,
+			"-f", "mp3",
+		)
+	} else {
+		args = append(args, "-map", "0:v:0?")
+		if len(inputs) > 1 {
+			args = append(args, "-map", "1:a:0?")
+		} else {
+			args = append(args, "-map", "0:a:0?")
+		}
+		args = append(args,
+			"-c", "copy",
+			"-movflags", "frag_keyframe+empty_moov+default_base_moof",
+			"-f", "mp4",
+		)
+	}
+
+	args = append(args, "pipe:1")
+
+	cmd := proc.CommandContext(ctx, limits(), binary, args...)
+
+	var stderr bytes.Buffer
+	cmd.ExtraFiles = inputs
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg stream failed: %w | stderr: %s", err, stderr.String())
+	}
+
+	return nil
+}
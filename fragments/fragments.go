@@ -0,0 +1,126 @@
+// Package fragments picks the --concurrent-fragments of each yt-dlp download
+// from the load of the server: the jobs downloading, a connection budget all
+// downloads share and the speed a platform gave earlier downloads
+package fragments
+
+import (
+	"expvar"
+	"math"
+	"sync"
+	"time"
+
+	"backend/config"
+	"backend/models"
+	"backend/pipeline"
+)
+
+// Weight of the newest download in a platform's speed
+const ewmaWeight = 0.3
+
+// Downloads shorter than this say more about startup than about speed
+const minMeasured = 5 * time.Second
+
+var (
+	inUse  int                        // connections held by running downloads
+	speeds = make(map[string]float64) // bytes per second per connection, by platform
+
+	mu sync.Mutex
+)
+
+func init() {
+	expvar.Publish("fragments", expvar.Func(func() any {
+		mu.Lock()
+		defer mu.Unlock()
+
+		platforms := make(map[string]int64, len(speeds))
+		for platform, speed := range speeds {
+			platforms[platform] = int64(speed)
+		}
+		return map[string]any{
+			"in_use":         inUse,
+			"budget":         config.Get().FragmentBudget,
+			"platform_speed": platforms,
+		}
+	}))
+}
+
+// Lease is the connections one download holds
+type Lease struct {
+	Tuning models.FragmentTuning
+
+	platform string
+	once     sync.Once
+}
+
+// Acquire picks the fragments of a download from platform that would use up
+// to wanted at most. Every download gets at least one, even past the budget.
+func Acquire(platform string, wanted int) *Lease {
+	cfg := config.Get()
+	active := max(pipeline.Stats()[pipeline.Fetch].Active, 1)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	n, reason := max(wanted, 1), "quality"
+
+	if cfg.FragmentBudget > 0 {
+		if share := max(cfg.FragmentBudget/active, 1); share < n {
+			n, reason = share, "fair share"
+		}
+		if free := max(cfg.FragmentBudget-inUse, 1); free < n {
+			n, reason = free, "budget"
+		}
+	}
+
+	// Sites DetectPlatform doesn't know have nothing in common
+	speed := 0.0
+	if platform != "" && platform != "Unknown" {
+		speed = speeds[platform]
+	}
+	if cfg.FragmentTargetSpeed > 0 && speed > 0 {
+		if needed := max(int(math.Ceil(float64(cfg.FragmentTargetSpeed)/speed)), 1); needed < n {
+			n, reason = needed, "throughput"
+		}
+	}
+
+	inUse += n
+
+	return &Lease{
+		Tuning: models.FragmentTuning{
+			Fragments:     n,
+			Wanted:        wanted,
+			Reason:        reason,
+			ActiveJobs:    active,
+			PlatformSpeed: int64(speed),
+		},
+		platform: platform,
+	}
+}
+
+// Done gives the connections back. bytes is what a finished download
+// fetched in elapsed, 0 for a failed one, and feeds the platform's speed.
+// Both count the transfer alone: bytes an earlier run left and the time
+// spent extracting or post-processing would skew the speed.
+func (l *Lease) Done(bytes int64, elapsed time.Duration) {
+	l.once.Do(func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		inUse -= l.Tuning.Fragments
+
+		if bytes <= 0 || elapsed <= 0 {
+			return
+		}
+
+		l.Tuning.Speed = int64(float64(bytes) / elapsed.Seconds())
+		if elapsed < minMeasured || l.platform == "" || l.platform == "Unknown" {
+			return
+		}
+
+		perConnection := float64(l.Tuning.Speed) / float64(l.Tuning.Fragments)
+		if previous, ok := speeds[l.platform]; ok {
+			perConnection = ewmaWeight*perConnection + (1-ewmaWeight)*previous
+		}
+		speeds[l.platform] = perConnection
+	})
+}
@@ -0,0 +1,217 @@
+// Package joblog keeps the output of the tools a job runs in a log file of
+// its own, data/logs/<id>.log. A log keeps its first half and its last half
+// of JOB_LOG_MAX_BYTES, the middle of a long download is progress lines.
+package joblog
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/config"
+)
+
+const stateDir = "data/logs"
+
+var ErrNotFound = errors.New("job log not found")
+
+// Log is the log of one job, its methods do nothing on a nil Log
+type Log struct {
+	id   string
+	file *os.File
+
+	mu      sync.Mutex
+	written int64  // bytes in the file
+	tail    []byte // lines past the head, the newest last
+	skipped int64  // bytes dropped from tail
+}
+
+var (
+	open = make(map[string]*Log)
+	mu   sync.Mutex
+)
+
+type ctxKey struct{}
+
+// Start opens the log of jobID, appending to what an earlier run of the job
+// wrote, and returns ctx carrying it. Close the log when the job is done.
+func Start(ctx context.Context, jobID string) (context.Context, *Log) {
+	l := get(jobID)
+	if l == nil {
+		return ctx, nil
+	}
+	return context.WithValue(ctx, ctxKey{}, l), l
+}
+
+// From returns the log ctx carries, nil outside of a job
+func From(ctx context.Context) *Log {
+	l, _ := ctx.Value(ctxKey{}).(*Log)
+	return l
+}
+
+// Printf adds a timestamped line to the log ctx carries
+func Printf(ctx context.Context, format string, args ...interface{}) {
+	From(ctx).Printf(format, args...)
+}
+
+func get(jobID string) *Log {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if l, ok := open[jobID]; ok {
+		return l
+	}
+
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		log.Printf("[JOBLOG] Failed to create log directory: %v", err)
+		return nil
+	}
+
+	f, err := os.OpenFile(path(jobID), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Printf("[JOBLOG] Failed to open log of %s: %v", jobID, err)
+		return nil
+	}
+
+	l := &Log{id: jobID, file: f}
+	if info, err := f.Stat(); err == nil {
+		l.written = info.Size()
+	}
+	open[jobID] = l
+	return l
+}
+
+// Printf adds a timestamped line
+func (l *Log) Printf(format string, args ...interface{}) {
+	if l == nil {
+		return
+	}
+	line := time.Now().UTC().Format("2006-01-02T15:04:05.000Z ") + fmt.Sprintf(format, args...)
+	l.Write([]byte(strings.TrimRight(line, "\n") + "\n"))
+}
+
+// Write adds raw output, so a Log can be a command's Stderr
+func (l *Log) Write(p []byte) (int, error) {
+	if l == nil {
+		return len(p), nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	half := config.Get().JobLogMaxBytes / 2
+
+	if l.file != nil && l.written < half {
+		n, err := l.file.Write(p)
+		l.written += int64(n)
+		return len(p), err
+	}
+
+	l.tail = append(l.tail, p...)
+	if over := int64(len(l.tail)) - half; over > 0 {
+		l.tail = l.tail[over:]
+		l.skipped += over
+	}
+	return len(p), nil
+}
+
+// Close writes out the tail and closes the file
+func (l *Log) Close() {
+	if l == nil {
+		return
+	}
+
+	mu.Lock()
+	delete(open, l.id)
+	mu.Unlock()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return
+	}
+	l.file.Write(l.tailLocked())
+	l.file.Close()
+	l.file = nil
+	l.tail = nil
+}
+
+func (l *Log) tailLocked() []byte {
+	var b bytes.Buffer
+	if l.skipped > 0 {
+		fmt.Fprintf(&b, "\n[... %d bytes skipped ...]\n\n", l.skipped)
+	}
+	b.Write(l.tail)
+	return b.Bytes()
+}
+
+// Read returns the log of jobID, including the tail a running job holds in memory
+func Read(jobID string) ([]byte, error) {
+	mu.Lock()
+	l := open[jobID]
+	mu.Unlock()
+
+	if l != nil {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+	}
+
+	data, err := os.ReadFile(path(jobID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if l != nil && l.file != nil {
+		data = append(data, l.tailLocked()...)
+	}
+	return data, nil
+}
+
+// Remove deletes the log of jobID
+func Remove(jobID string) {
+	if err := os.Remove(path(jobID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("[JOBLOG] Failed to delete log of %s: %v", jobID, err)
+	}
+}
+
+// DeleteOlderThan removes logs not written to for age, unless keep(jobID) says otherwise
+func DeleteOlderThan(age time.Duration, keep func(jobID string) bool) {
+	entries, err := os.ReadDir(stateDir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".log")
+		if !ok {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < age || keep(id) {
+			continue
+		}
+
+		mu.Lock()
+		_, running := open[id]
+		mu.Unlock()
+		if !running {
+			Remove(id)
+		}
+	}
+}
+
+func path(jobID string) string {
+	return filepath.Join(stateDir, filepath.Base(jobID)+".log")
+}
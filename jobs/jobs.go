@@ -0,0 +1,54 @@
+package jobs
+
+import "sync"
+
+// Job is a download that is currently running and can be stopped
+type Job struct {
+	ID   string
+	Live bool
+	stop func()
+}
+
+var (
+	running = make(map[string]*Job)
+	mu      sync.RWMutex
+)
+
+func Register(id string, live bool, stop func()) *Job {
+	mu.Lock()
+	defer mu.Unlock()
+
+	job := &Job{
+		ID:   id,
+		Live: live,
+		stop: stop,
+	}
+	running[id] = job
+	return job
+}
+
+func Get(id string) *Job {
+	mu.RLock()
+	defer mu.RUnlock()
+	return running[id]
+}
+
+func Unregister(id string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(running, id)
+}
+
+// Stop asks a running job to finish, returns false if the job is unknown
+func Stop(id string) bool {
+	mu.RLock()
+	job := running[id]
+	mu.RUnlock()
+
+	if job == nil {
+		return false
+	}
+
+	job.stop()
+	return true
+}
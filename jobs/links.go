@@ -0,0 +1,71 @@
+package jobs
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrLinkUsed is returned for a once link another request holds or that was spent
+var ErrLinkUsed = errors.New("download link already used")
+
+var (
+	// Once links in use or spent, by signature, with their expiry
+	claimedLinks   = make(map[string]int64)
+	claimedLinksMu sync.Mutex
+)
+
+// ClaimLink reserves the once link sig of job id for a single request, it
+// fails while another request holds the link or once it was spent. done
+// ends the claim, used spends the link for good: it is kept on the record so
+// it stays spent across restarts. A link that wasn't used can be claimed again.
+func ClaimLink(id, sig string, expires int64) (done func(used bool), err error) {
+	claimedLinksMu.Lock()
+	defer claimedLinksMu.Unlock()
+
+	now := time.Now().Unix()
+	for s, exp := range claimedLinks {
+		if exp <= now {
+			delete(claimedLinks, s)
+		}
+	}
+
+	if _, claimed := claimedLinks[sig]; claimed || spent(id, sig) {
+		return nil, ErrLinkUsed
+	}
+	claimedLinks[sig] = expires
+
+	return func(used bool) {
+		claimedLinksMu.Lock()
+		defer claimedLinksMu.Unlock()
+
+		if !used {
+			delete(claimedLinks, sig)
+			return
+		}
+
+		Update(id, func(record *Record) {
+			for s, exp := range record.UsedLinks {
+				if exp <= time.Now().Unix() {
+					delete(record.UsedLinks, s)
+				}
+			}
+			if record.UsedLinks == nil {
+				record.UsedLinks = make(map[string]int64)
+			}
+			record.UsedLinks[sig] = expires
+		})
+	}, nil
+}
+
+func spent(id, sig string) bool {
+	recordsMu.RLock()
+	defer recordsMu.RUnlock()
+
+	record := records[id]
+	if record == nil {
+		return false
+	}
+	_, used := record.UsedLinks[sig]
+	return used
+}
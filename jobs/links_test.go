@@ -0,0 +1,50 @@
+package jobs
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestClaimLink(t *testing.T) {
+	inTempDir(t)
+
+	if err := Save(&Record{ID: "done", Status: StatusCompleted}); err != nil {
+		t.Fatal(err)
+	}
+	expires := time.Now().Add(time.Hour).Unix()
+
+	done, err := ClaimLink("done", "sig", expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ClaimLink("done", "sig", expires); !errors.Is(err, ErrLinkUsed) {
+		t.Fatalf("second claim while held: err = %v, want ErrLinkUsed", err)
+	}
+
+	// Not used, e.g. the transfer was cut short
+	done(false)
+	done, err = ClaimLink("done", "sig", expires)
+	if err != nil {
+		t.Fatalf("claim after an unused one: %v", err)
+	}
+	done(true)
+
+	// A restart forgets the claims, the record on disk still has the link
+	claimedLinksMu.Lock()
+	claimedLinks = make(map[string]int64)
+	claimedLinksMu.Unlock()
+	recordsMu.Lock()
+	records = make(map[string]*Record)
+	recordsMu.Unlock()
+
+	if _, err := LoadUnfinished(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ClaimLink("done", "sig", expires); !errors.Is(err, ErrLinkUsed) {
+		t.Fatalf("claim of a spent link after a restart: err = %v, want ErrLinkUsed", err)
+	}
+	if _, err := ClaimLink("done", "other", expires); err != nil {
+		t.Fatalf("claim of another link: %v", err)
+	}
+}
@@ -0,0 +1,284 @@
+package jobs
+
+import (
+	"backend/models"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+	StatusFailed    Status = "failed"
+	StatusStopped   Status = "stopped"
+	StatusExpired   Status = "expired" // finished, file removed by retention
+)
+
+// Record is the persisted state of a job, it survives restarts so
+// unfinished downloads can be picked up again
+type Record struct {
+	ID         string                      `json:"id"`
+	Request    models.DownloadVideoRequest `json:"request"`
+	Status     Status                      `json:"status"`
+	OutputPath string                      `json:"output_path,omitempty"`
+	Downloader string                      `json:"downloader,omitempty"` // backend that fetched the file
+	Stage      string                      `json:"stage,omitempty"`      // pipeline stage the job is in or waiting for
+	Fragments  *models.FragmentTuning      `json:"fragments,omitempty"`  // last yt-dlp attempt
+	Result     *models.VideoDownloadResult `json:"result,omitempty"`
+	AttachedTo string                      `json:"attached_to,omitempty"` // primary job of a deduplicated request
+	Resumes    int                         `json:"resumes"`
+	UsedLinks  map[string]int64            `json:"used_links,omitempty"` // spent once links, by signature, with their expiry
+	CreatedAt  int64                       `json:"created_at"`
+	UpdatedAt  int64                       `json:"updated_at"`
+}
+
+func (r *Record) Finished() bool {
+	return r.Status == StatusCompleted || r.Status == StatusFailed ||
+		r.Status == StatusStopped || r.Status == StatusExpired
+}
+
+const stateDir = "data/jobs"
+
+var (
+	records   = make(map[string]*Record)
+	recordsMu sync.RWMutex
+
+	// Output paths without extension of unfinished jobs, by the directory
+	// they are in, and that directory by job. Guarded by recordsMu.
+	activeDirs = make(map[string]string)
+	activeJobs = make(map[string]string)
+
+	// One lock per job, so writes of a record reach the disk in the order they were encoded
+	writeLocks   = make(map[string]*sync.Mutex)
+	writeLocksMu sync.Mutex
+)
+
+// Save stores the record in memory and writes it to disk
+func Save(record *Record) error {
+	writeMu := writeLock(record.ID)
+	writeMu.Lock()
+	defer writeMu.Unlock()
+
+	recordsMu.Lock()
+	now := time.Now().Unix()
+	if record.CreatedAt == 0 {
+		record.CreatedAt = now
+	}
+	record.UpdatedAt = now
+	records[record.ID] = record
+	indexLocked(record)
+	data, err := json.MarshalIndent(record, "", "  ")
+	recordsMu.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("failed to encode job %s: %w", record.ID, err)
+	}
+
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		return fmt.Errorf("failed to create job state directory: %w", err)
+	}
+
+	// Write then rename so a crash never leaves a half written record
+	path := filepath.Join(stateDir, record.ID+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write job %s: %w", record.ID, err)
+	}
+	return os.Rename(tmp, path)
+}
+
+func writeLock(id string) *sync.Mutex {
+	writeLocksMu.Lock()
+	defer writeLocksMu.Unlock()
+
+	l, ok := writeLocks[id]
+	if !ok {
+		l = &sync.Mutex{}
+		writeLocks[id] = l
+	}
+	return l
+}
+
+// Update applies fn to a known record and persists it
+func Update(id string, fn func(record *Record)) {
+	recordsMu.Lock()
+	record := records[id]
+	if record != nil {
+		fn(record)
+	}
+	recordsMu.Unlock()
+
+	if record == nil {
+		return
+	}
+
+	if err := Save(record); err != nil {
+		log.Printf("[JOBS] Failed to save job %s: %v", id, err)
+	}
+}
+
+func SetStatus(id string, status Status) {
+	Update(id, func(record *Record) {
+		record.Status = status
+	})
+}
+
+func Lookup(id string) *Record {
+	recordsMu.RLock()
+	defer recordsMu.RUnlock()
+	return records[id]
+}
+
+func All() []*Record {
+	recordsMu.RLock()
+	defer recordsMu.RUnlock()
+
+	all := make([]*Record, 0, len(records))
+	for _, record := range records {
+		all = append(all, record)
+	}
+	return all
+}
+
+// LoadUnfinished reads every persisted job and returns the ones that were
+// queued or running when the process stopped
+func LoadUnfinished() ([]*Record, error) {
+	entries, err := os.ReadDir(stateDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var unfinished []*Record
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(stateDir, entry.Name()))
+		if err != nil {
+			log.Printf("[JOBS] Failed to read %s: %v", entry.Name(), err)
+			continue
+		}
+
+		var record Record
+		if err := json.Unmarshal(data, &record); err != nil {
+			log.Printf("[JOBS] Corrupt job record %s: %v", entry.Name(), err)
+			continue
+		}
+
+		recordsMu.Lock()
+		records[record.ID] = &record
+		indexLocked(&record)
+		recordsMu.Unlock()
+
+		if !record.Finished() {
+			unfinished = append(unfinished, &record)
+		}
+	}
+
+	return unfinished, nil
+}
+
+// IsActiveFile reports whether path belongs to a job that has not finished,
+// including its .part and fragment files and the job directory itself
+func IsActiveFile(path string) bool {
+	path = filepath.Clean(path)
+
+	recordsMu.RLock()
+	defer recordsMu.RUnlock()
+
+	// The job directory itself
+	if _, ok := activeDirs[path]; ok {
+		return true
+	}
+
+	// A file in the job directory or below it, e.g. a gallery folder
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if base, ok := activeDirs[dir]; ok && strings.HasPrefix(path, base) {
+			return true
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			return false
+		}
+	}
+}
+
+// indexLocked keeps the output path of record in the index IsActiveFile
+// looks at while the job is unfinished. recordsMu must be held.
+func indexLocked(record *Record) {
+	if dir, ok := activeJobs[record.ID]; ok {
+		delete(activeDirs, dir)
+		delete(activeJobs, record.ID)
+	}
+	if record.Finished() || record.OutputPath == "" {
+		return
+	}
+
+	output := filepath.Clean(record.OutputPath)
+	dir := filepath.Dir(output)
+	activeDirs[dir] = strings.TrimSuffix(output, filepath.Ext(output))
+	activeJobs[record.ID] = dir
+}
+
+// Prune deletes the records of jobs that finished more than age ago, from
+// memory and disk, unless keep says otherwise, e.g. for a retained file
+func Prune(age time.Duration, keep func(id string) bool) {
+	cutoff := time.Now().Add(-age).Unix()
+
+	var stale []string
+	recordsMu.RLock()
+	for id, record := range records {
+		if record.Finished() && record.UpdatedAt < cutoff {
+			stale = append(stale, id)
+		}
+	}
+	recordsMu.RUnlock()
+
+	for _, id := range stale {
+		if keep(id) {
+			continue
+		}
+		if err := Delete(id); err != nil {
+			log.Printf("[JOBS] Failed to delete job %s: %v", id, err)
+		}
+	}
+}
+
+// Delete forgets the record of a job and removes its file
+func Delete(id string) error {
+	writeMu := writeLock(id)
+	writeMu.Lock()
+
+	recordsMu.Lock()
+	if dir, ok := activeJobs[id]; ok {
+		delete(activeDirs, dir)
+		delete(activeJobs, id)
+	}
+	delete(records, id)
+	recordsMu.Unlock()
+
+	err := os.Remove(filepath.Join(stateDir, id+".json"))
+	writeMu.Unlock()
+
+	writeLocksMu.Lock()
+	delete(writeLocks, id)
+	writeLocksMu.Unlock()
+
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
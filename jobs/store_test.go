@@ -0,0 +1,102 @@
+package jobs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// inTempDir runs the test in an empty working directory, records go to data/jobs
+func inTempDir(t *testing.T) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestIsActiveFile(t *testing.T) {
+	inTempDir(t)
+
+	if err := Save(&Record{ID: "running", Status: StatusRunning, OutputPath: "downloads/running/video.%(ext)s"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Save(&Record{ID: "done", Status: StatusCompleted, OutputPath: "downloads/done/video.mp4"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		Delete("running")
+		Delete("done")
+	})
+
+	tests := map[string]bool{
+		"downloads/running":                         true,
+		"downloads/running/video.f137.mp4.part":     true,
+		"downloads/running/video.gallery/image.jpg": true,
+		"downloads/running/other.mp4":               false,
+		"downloads/done":                            false,
+		"downloads/done/video.mp4":                  false,
+		"downloads":                                 false,
+	}
+	for path, want := range tests {
+		if got := IsActiveFile(path); got != want {
+			t.Errorf("IsActiveFile(%q) = %v, want %v", path, got, want)
+		}
+	}
+
+	SetStatus("running", StatusCompleted)
+	if IsActiveFile("downloads/running/video.mp4") {
+		t.Error("file of a finished job is still active")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	inTempDir(t)
+
+	for _, record := range []*Record{
+		{ID: "old-failed", Status: StatusFailed},
+		{ID: "old-retained", Status: StatusCompleted},
+		{ID: "old-running", Status: StatusRunning},
+		{ID: "new-failed", Status: StatusFailed},
+	} {
+		if err := Save(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		for _, id := range []string{"old-failed", "old-retained", "old-running", "new-failed"} {
+			Delete(id)
+		}
+	})
+
+	recordsMu.Lock()
+	for _, id := range []string{"old-failed", "old-retained", "old-running"} {
+		records[id].UpdatedAt = time.Now().Add(-2 * time.Hour).Unix()
+	}
+	recordsMu.Unlock()
+
+	Prune(time.Hour, func(id string) bool { return id == "old-retained" })
+
+	for id, want := range map[string]bool{"old-failed": false, "old-retained": true, "old-running": true, "new-failed": true} {
+		if got := Lookup(id) != nil; got != want {
+			t.Errorf("record %s kept = %v, want %v", id, got, want)
+		}
+		_, err := os.Stat(filepath.Join(stateDir, id+".json"))
+		if got := err == nil; got != want {
+			t.Errorf("file of %s kept = %v, want %v", id, got, want)
+		}
+	}
+
+	writeLocksMu.Lock()
+	_, locked := writeLocks["old-failed"]
+	writeLocksMu.Unlock()
+	if locked {
+		t.Error("write lock of a pruned job is kept")
+	}
+}
@@ -5,6 +5,7 @@ type VideoType string
 const (
 	VideoTypeReel  VideoType = "reel"
 	VideoTypeVideo VideoType = "video"
+	VideoTypeLive  VideoType = "live"
 )
 
 type Confidence string
@@ -16,11 +17,16 @@ const (
 )
 
 type Request struct {
-	URL         string `json:"url"`
-	Quality     string `json:"quality"`
-	AudioOnly   bool   `json:"audio_only,omitempty"`
-	UserID      string `json:"user_id,omitempty"`
-	CloudUpload bool   `json:"cloud_upload,omitempty"`
+	URL           string `json:"url"`
+	Quality       string `json:"quality"`
+	AudioOnly     bool   `json:"audio_only,omitempty"`
+	UserID        string `json:"user_id,omitempty"`
+	CloudUpload   bool   `json:"cloud_upload,omitempty"`
+	LiveFromStart bool   `json:"live_from_start,omitempty"`
+	MaxDuration   int    `json:"max_duration,omitempty"` // seconds, live recordings only
+	ClipStart     string `json:"clip_start,omitempty"`   // e.g. "90" or "00:01:30"
+	ClipEnd       string `json:"clip_end,omitempty"`
+	OneTimeLink   bool   `json:"one_time_link,omitempty"`
 }
 
 type DownloadVideoRequest struct {
@@ -31,6 +37,8 @@ type DownloadVideoRequest struct {
 	Title        string  `json:"title"`
 	Platform     string  `json:"platform"`
 	VideoType    string  `json:"video_type"`
+	IsLive       bool    `json:"is_live"`
+	Duration     float64 `json:"duration,omitempty"` // seconds, from metadata when known
 }
 
 type Format struct {
@@ -54,6 +62,9 @@ type VideoInfo struct {
 	UploadDate  *string `json:"upload_date,omitempty"`
 	LikeCount   *int64  `json:"likes,omitempty"`
 	VideoPage   string  `json:"url"`
+	IsLive      bool    `json:"is_live,omitempty"`
+	LiveStatus  string  `json:"live_status,omitempty"` // yt-dlp's, e.g. "is_live" or "was_live", "" when unknown
+	Duration    float64 `json:"duration,omitempty"`
 }
 
 type DownloadProgress struct {
@@ -69,10 +80,41 @@ type DownloadProgress struct {
 type VideoDownloadResult struct {
 	RequestID   string `json:"request_id"`
 	FilePath    string `json:"file_path"`
+	StorageKey  string `json:"storage_key"`
 	Title       string `json:"title"`
 	FileName    string `json:"file_name"`
 	DownloadURL string `json:"download_url"`
 	CleanupAt   int64  `json:"cleanup_at"`
+
+	Media    *MediaProbe `json:"media,omitempty"`
+	Warnings []string    `json:"warnings,omitempty"`
+
+	// Streams are the video and audio files still to be merged into FilePath
+	Streams []string `json:"-"`
+}
+
+// MediaProbe is what ffprobe found in the downloaded file
+type MediaProbe struct {
+	Width      int     `json:"width,omitempty"`
+	Height     int     `json:"height,omitempty"`
+	FPS        float64 `json:"fps,omitempty"`
+	Duration   float64 `json:"duration,omitempty"` // seconds
+	VideoCodec string  `json:"video_codec,omitempty"`
+	AudioCodec string  `json:"audio_codec,omitempty"`
+	Bitrate    int64   `json:"bitrate,omitempty"` // bits per second
+	FileSize   int64   `json:"file_size"`
+	SHA256     string  `json:"sha256,omitempty"`
+}
+
+// FragmentTuning is how the --concurrent-fragments of a download, or the
+// connections of a direct one, were picked and the speed the download got with them
+type FragmentTuning struct {
+	Fragments     int    `json:"fragments"`
+	Wanted        int    `json:"wanted"` // what the quality or DIRECT_CONNECTIONS alone would get
+	Reason        string `json:"reason"` // the limit that decided, e.g. "budget"
+	ActiveJobs    int    `json:"active_jobs"`
+	PlatformSpeed int64  `json:"platform_speed,omitempty"` // bytes per second per connection, as measured before
+	Speed         int64  `json:"speed,omitempty"`          // bytes per second of this download
 }
 
 type PlatformInfo struct {
@@ -82,7 +124,36 @@ type PlatformInfo struct {
 }
 
 type StreamVideoDownloadRequest struct {
-	URL string `json:"url"`
+	URL       string `json:"url"`
+	Quality   string `json:"quality,omitempty"`
+	AudioOnly bool   `json:"audio_only,omitempty"`
+}
+
+// ResolvedFormat is one direct media URL picked by yt-dlp, HTTPHeaders
+// must be sent along or the CDN may refuse the request
+type ResolvedFormat struct {
+	FormatID    string            `json:"format_id"`
+	URL         string            `json:"url"`
+	Extension   string            `json:"ext"`
+	Protocol    string            `json:"protocol"`
+	Vcodec      string            `json:"vcodec,omitempty"`
+	Acodec      string            `json:"acodec,omitempty"`
+	Width       int               `json:"width,omitempty"`
+	Height      int               `json:"height,omitempty"`
+	HTTPHeaders map[string]string `json:"http_headers,omitempty"`
+	// ExpiresAt is when the CDN stops accepting URL (unix seconds), 0 when unknown
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+}
+
+// ResolvedMedia lists the formats yt-dlp would download for a format
+// chain, two entries when video and audio come separately
+type ResolvedMedia struct {
+	Title    string           `json:"title"`
+	Duration float64          `json:"duration,omitempty"`
+	IsLive   bool             `json:"is_live,omitempty"`
+	Formats  []ResolvedFormat `json:"formats"`
+	// ExpiresAt is the earliest expiry of the formats, 0 when unknown
+	ExpiresAt int64 `json:"expires_at,omitempty"`
 }
 
 type YtdlpInfo struct {
@@ -94,4 +165,7 @@ type YtdlpInfo struct {
 	UploadDate  *string `json:"upload_date"`
 	LikeCount   *int64  `json:"likes"`
 	URL         *string `json:"url"`
+	IsLive      bool    `json:"is_live"`
+	LiveStatus  string  `json:"live_status"`
+	Duration    float64 `json:"duration"`
 }
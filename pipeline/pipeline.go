@@ -0,0 +1,164 @@
+// Package pipeline splits a job into stages with worker pools of their own,
+// so ffmpeg conversions and uploads don't hold the slots of network downloads
+// and the other way around. A job takes a worker of each stage in turn and
+// hands it back before queuing for the next one.
+package pipeline
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"backend/config"
+)
+
+type Stage string
+
+const (
+	Fetch       Stage = "fetch"       // network download by the backend
+	Merge       Stage = "merge"       // ffmpeg audio extraction and remuxing
+	PostProcess Stage = "postprocess" // probe and checksum of the file
+	Upload      Stage = "upload"      // hand-off to the storage backend
+)
+
+// Stages lists the stages in the order a job runs through them
+var Stages = []Stage{Fetch, Merge, PostProcess, Upload}
+
+type pool struct {
+	slots  chan struct{}
+	queued atomic.Int64
+}
+
+var (
+	pools = make(map[Stage]*pool)
+	mu    sync.RWMutex
+)
+
+func init() {
+	expvar.Publish("pipeline", expvar.Func(func() any {
+		return Stats()
+	}))
+}
+
+// Setup sizes the pool of every stage from the config
+func Setup(cfg *config.Config) error {
+	sizes := map[Stage]int{
+		Fetch:       cfg.FetchWorkers,
+		Merge:       cfg.MergeWorkers,
+		PostProcess: cfg.PostProcessWorkers,
+		Upload:      cfg.UploadWorkers,
+	}
+
+	created := make(map[Stage]*pool, len(sizes))
+	for _, stage := range Stages {
+		if sizes[stage] <= 0 {
+			return fmt.Errorf("%s workers must be at least 1, got %d", stage, sizes[stage])
+		}
+		created[stage] = &pool{slots: make(chan struct{}, sizes[stage])}
+	}
+
+	mu.Lock()
+	pools = created
+	mu.Unlock()
+
+	log.Printf("[PIPELINE] Workers | Fetch=%d | Merge=%d | PostProcess=%d | Upload=%d",
+		sizes[Fetch], sizes[Merge], sizes[PostProcess], sizes[Upload])
+	return nil
+}
+
+// Worker is a job's place in a stage
+type Worker struct {
+	pool *pool
+	once sync.Once
+}
+
+// Acquire waits for a worker of stage. waiting is called once, if at all,
+// when the caller has to queue.
+func Acquire(ctx context.Context, stage Stage, waiting func()) (*Worker, error) {
+	p, err := poolOf(stage)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case p.slots <- struct{}{}:
+		return &Worker{pool: p}, nil
+	default:
+	}
+
+	if waiting != nil {
+		waiting()
+	}
+
+	p.queued.Add(1)
+	defer p.queued.Add(-1)
+
+	select {
+	case p.slots <- struct{}{}:
+		return &Worker{pool: p}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// TryAcquire takes a worker of stage only if one is free right now
+func TryAcquire(stage Stage) (*Worker, bool) {
+	p, err := poolOf(stage)
+	if err != nil {
+		return nil, false
+	}
+
+	select {
+	case p.slots <- struct{}{}:
+		return &Worker{pool: p}, true
+	default:
+		return nil, false
+	}
+}
+
+// Release hands the worker back, calling it again does nothing
+func (w *Worker) Release() {
+	if w == nil {
+		return
+	}
+	w.once.Do(func() {
+		<-w.pool.slots
+	})
+}
+
+// StageStats is the load of one stage
+type StageStats struct {
+	Workers int   `json:"workers"`
+	Active  int   `json:"active"`
+	Queued  int64 `json:"queued"`
+}
+
+// Stats returns the load of every stage, also published as the "pipeline" expvar
+func Stats() map[Stage]StageStats {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	stats := make(map[Stage]StageStats, len(pools))
+	for stage, p := range pools {
+		stats[stage] = StageStats{
+			Workers: cap(p.slots),
+			Active:  len(p.slots),
+			Queued:  p.queued.Load(),
+		}
+	}
+	return stats
+}
+
+func poolOf(stage Stage) (*pool, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	p, ok := pools[stage]
+	if !ok {
+		return nil, fmt.Errorf("pipeline stage %q is not set up", stage)
+	}
+	return p, nil
+}
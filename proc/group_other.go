@@ -0,0 +1,22 @@
+//go:build !unix
+
+package proc
+
+import (
+	"os"
+	"os/exec"
+)
+
+// Without process groups only the direct child is signalled
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func interruptGroup(cmd *exec.Cmd) error {
+	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+		return cmd.Process.Kill()
+	}
+	return nil
+}
+
+func killGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
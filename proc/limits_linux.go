@@ -0,0 +1,94 @@
+//go:build linux
+
+package proc
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	ioprioWhoPgrp    = 2
+	ioprioClassShift = 13
+	ioprioClassBE    = 2
+)
+
+// lowerPriority renices the process group of pid and moves it to the lowest
+// best-effort IO priority. Processes started later inherit both.
+func lowerPriority(pid, nice int) error {
+	if err := unix.Setpriority(unix.PRIO_PGRP, pid, nice); err != nil {
+		return fmt.Errorf("setpriority: %w", err)
+	}
+
+	prio := ioprioClassBE<<ioprioClassShift | 7
+	if _, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoPgrp, uintptr(pid), uintptr(prio)); errno != 0 {
+		return fmt.Errorf("ioprio_set: %w", errno)
+	}
+	return nil
+}
+
+// joinCgroup creates a cgroup v2 under root limited to limit bytes and starts
+// cmd inside it, so yt-dlp and every ffmpeg it spawns share the limit. root
+// has to be a delegated cgroup the server can write to. The returned func
+// removes the cgroup once the process exited.
+func joinCgroup(cmd *exec.Cmd, root string, limit int64) (func(), error) {
+	b := make([]byte, 6)
+	rand.Read(b)
+	dir := filepath.Join(root, "job-"+hex.EncodeToString(b))
+
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cgroup: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "memory.max"), []byte(strconv.FormatInt(limit, 10)), 0644); err != nil {
+		os.Remove(dir)
+		return nil, fmt.Errorf("failed to set memory.max: %w", err)
+	}
+	// Swap would only slow the box down instead of enforcing the limit,
+	// and an OOM kills the whole group instead of leaving yt-dlp without ffmpeg
+	os.WriteFile(filepath.Join(dir, "memory.swap.max"), []byte("0"), 0644)
+	os.WriteFile(filepath.Join(dir, "memory.oom.group"), []byte("1"), 0644)
+
+	f, err := os.Open(dir)
+	if err != nil {
+		os.Remove(dir)
+		return nil, fmt.Errorf("failed to open cgroup: %w", err)
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(f.Fd())
+
+	return func() {
+		f.Close()
+
+		if events, err := os.ReadFile(filepath.Join(dir, "memory.events")); err == nil && oomKilled(events) {
+			log.Printf("[PROC] %s was killed for exceeding the memory limit of %d bytes", cmd.Path, limit)
+		}
+		if err := os.Remove(dir); err != nil {
+			log.Printf("[PROC] Failed to remove cgroup %s: %v", dir, err)
+		}
+	}, nil
+}
+
+// oomKilled reads the oom_kill counter of memory.events
+func oomKilled(events []byte) bool {
+	for _, line := range bytes.Split(events, []byte("\n")) {
+		if v, ok := bytes.CutPrefix(line, []byte("oom_kill ")); ok {
+			return string(v) != "0"
+		}
+	}
+	return false
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package proc
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func lowerPriority(pid, nice int) error {
+	return errors.New("not supported on this platform")
+}
+
+func joinCgroup(cmd *exec.Cmd, root string, limit int64) (func(), error) {
+	return nil, errors.New("cgroups need Linux")
+}
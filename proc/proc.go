@@ -0,0 +1,176 @@
+// Package proc runs yt-dlp and ffmpeg children with a time limit, in their
+// own process group, at a lower CPU and IO priority and, on Linux, inside a
+// memory limited cgroup.
+package proc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/config"
+	"backend/joblog"
+)
+
+// ErrTimeout is wrapped into the error of a run that hit its time limit
+var ErrTimeout = errors.New("time limit exceeded")
+
+// Options tune one child process
+type Options struct {
+	// Timeout kills the process group after this long, 0 for no limit
+	Timeout time.Duration
+
+	// Graceful interrupts the group first, so yt-dlp and ffmpeg can finish
+	// the file they are writing, and kills it after Grace (30s by default)
+	Graceful bool
+	Grace    time.Duration
+}
+
+// Cmd is an exec.Cmd with the limits applied, Start, Wait and Run replace
+// the exec.Cmd ones
+type Cmd struct {
+	*exec.Cmd
+
+	opts    Options
+	ctx     context.Context
+	cancel  context.CancelFunc
+	cleanup []func()
+
+	// log is the job log of ctx, it gets the argv, stderr and exit status
+	log     *joblog.Log
+	started time.Time
+
+	mu     sync.Mutex
+	exited bool
+}
+
+// CommandContext is exec.CommandContext with the limits of opts and the
+// process settings from the config
+func CommandContext(ctx context.Context, opts Options, name string, args ...string) *Cmd {
+	var cancel context.CancelFunc = func() {}
+	if opts.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+	}
+	if opts.Grace <= 0 {
+		opts.Grace = 30 * time.Second
+	}
+
+	c := &Cmd{
+		Cmd:    exec.CommandContext(ctx, name, args...),
+		opts:   opts,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	setProcessGroup(c.Cmd)
+
+	c.Cmd.Cancel = func() error {
+		if !c.opts.Graceful {
+			return killGroup(c.Cmd)
+		}
+		// Children are killed too when the group ignores the interrupt
+		time.AfterFunc(c.opts.Grace, func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if !c.exited {
+				killGroup(c.Cmd)
+			}
+		})
+		return interruptGroup(c.Cmd)
+	}
+	c.Cmd.WaitDelay = opts.Grace + 5*time.Second
+
+	return c
+}
+
+// Start starts the process and applies priority and memory limits
+func (c *Cmd) Start() error {
+	cfg := config.Get()
+
+	if c.log = joblog.From(c.ctx); c.log != nil {
+		c.log.Printf("$ %s", redactArgs(c.Args))
+		// When stderr shares stdout's pipe the caller reads and logs both
+		if c.Stderr != nil && c.Stderr != c.Stdout {
+			c.Stderr = io.MultiWriter(c.Stderr, c.log)
+		}
+	}
+	c.started = time.Now()
+
+	if cfg.ProcessMemoryLimit > 0 {
+		if release, err := joinCgroup(c.Cmd, cfg.CgroupRoot, cfg.ProcessMemoryLimit); err != nil {
+			warnOnce("[PROC] Memory limit not applied: %v", err)
+		} else {
+			c.cleanup = append(c.cleanup, release)
+		}
+	}
+
+	if err := c.Cmd.Start(); err != nil {
+		c.log.Printf("start failed: %v", err)
+		c.finish()
+		return err
+	}
+
+	if cfg.ProcessNice > 0 {
+		if err := lowerPriority(c.Cmd.Process.Pid, cfg.ProcessNice); err != nil {
+			warnOnce("[PROC] Priority not lowered: %v", err)
+		}
+	}
+	return nil
+}
+
+// Wait waits for the process, an error caused by the time limit wraps ErrTimeout
+func (c *Cmd) Wait() error {
+	err := c.Cmd.Wait()
+
+	c.mu.Lock()
+	c.exited = true
+	c.mu.Unlock()
+
+	timedOut := errors.Is(c.ctx.Err(), context.DeadlineExceeded)
+	c.finish()
+
+	if err != nil && timedOut {
+		err = fmt.Errorf("%s killed after %s: %w: %w", c.Cmd.Path, c.opts.Timeout, ErrTimeout, err)
+	}
+
+	if err != nil {
+		c.log.Printf("exited after %s: %v", time.Since(c.started).Round(time.Millisecond), err)
+	} else {
+		c.log.Printf("exited after %s", time.Since(c.started).Round(time.Millisecond))
+	}
+	return err
+}
+
+// Run starts the process and waits for it
+func (c *Cmd) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+func (c *Cmd) finish() {
+	c.cancel()
+	for _, fn := range c.cleanup {
+		fn()
+	}
+	c.cleanup = nil
+}
+
+// redactArgs joins argv for the log, without proxy passwords
+func redactArgs(args []string) string {
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		redacted[i] = arg
+		if u, err := url.Parse(arg); err == nil && u.User != nil {
+			redacted[i] = u.Redacted()
+		}
+	}
+	return strings.Join(redacted, " ")
+}
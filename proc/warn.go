@@ -0,0 +1,15 @@
+package proc
+
+import (
+	"log"
+	"sync"
+)
+
+// warned keeps a failing limit from logging on every process, keyed by format
+var warned sync.Map
+
+func warnOnce(format string, args ...interface{}) {
+	if _, loaded := warned.LoadOrStore(format, true); !loaded {
+		log.Printf(format, args...)
+	}
+}
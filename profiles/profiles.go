@@ -0,0 +1,91 @@
+// Package profiles holds the per-platform yt-dlp settings of RUNNER_PROFILES,
+// reloaded without a restart through the admin API or SIGHUP
+package profiles
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"backend/config"
+	util "backend/utils"
+	ytdlp "backend/yt-dlp"
+)
+
+// Default applies to platforms without a profile of their own
+const Default = "default"
+
+var (
+	profiles = make(map[string]ytdlp.Profile)
+	mu       sync.RWMutex
+)
+
+// Load reads RUNNER_PROFILES, a JSON object of platform name to profile:
+//
+//	{"YouTube": {"extractor_args": ["youtube:player_client=web,android"]},
+//	 "TikTok": {"impersonate": "chrome", "sleep_requests": 1}}
+//
+// A file that doesn't parse or validate leaves the current profiles in place.
+func Load() error {
+	path := config.Get().RunnerProfilesFile
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read runner profiles: %w", err)
+	}
+
+	var raw map[string]ytdlp.Profile
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("failed to parse runner profiles: %w", err)
+	}
+
+	loaded := make(map[string]ytdlp.Profile, len(raw))
+	for name, profile := range raw {
+		platform := Default
+		if name != Default {
+			var ok bool
+			if platform, ok = util.CanonicalPlatform(name); !ok {
+				return fmt.Errorf("unknown platform %q in runner profiles", name)
+			}
+		}
+		if err := profile.Validate(); err != nil {
+			return fmt.Errorf("invalid runner profile for %s: %w", name, err)
+		}
+		loaded[platform] = profile
+	}
+
+	mu.Lock()
+	profiles = loaded
+	mu.Unlock()
+
+	log.Printf("[PROFILES] Loaded %d runner profiles from %s", len(loaded), path)
+	return nil
+}
+
+// For returns the profile of platform, the default profile when it has none
+func For(platform string) ytdlp.Profile {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if profile, ok := profiles[platform]; ok {
+		return profile
+	}
+	return profiles[Default]
+}
+
+// All returns the loaded profiles by platform
+func All() map[string]ytdlp.Profile {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	all := make(map[string]ytdlp.Profile, len(profiles))
+	for platform, profile := range profiles {
+		all[platform] = profile
+	}
+	return all
+}
@@ -0,0 +1,218 @@
+// Package ratelimit throttles the requests sent to each platform, with a
+// token bucket for the request rate and a cap on concurrent jobs
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/config"
+	util "backend/utils"
+)
+
+// Limit is the budget of one platform, 0 disables either part
+type Limit struct {
+	RequestsPerMinute int
+	Concurrency       int
+}
+
+type bucket struct {
+	limit Limit
+
+	tokens float64
+	last   time.Time
+	active int
+
+	// Closed and replaced every time a slot is released, wakes waiting jobs
+	freed chan struct{}
+}
+
+var (
+	defaults  Limit
+	overrides = make(map[string]Limit)
+	buckets   = make(map[string]*bucket)
+
+	mu sync.Mutex
+)
+
+// Setup reads the default limit and the per-platform overrides of RATE_LIMITS,
+// e.g. "TikTok=20/2,Instagram=10/1" for 20 requests a minute and 2 jobs at a time
+func Setup(cfg *config.Config) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	defaults = Limit{RequestsPerMinute: cfg.RateLimitRPM, Concurrency: cfg.RateLimitConcurrency}
+	overrides = make(map[string]Limit)
+	buckets = make(map[string]*bucket)
+
+	for _, entry := range cfg.RateLimits {
+		name, spec, ok := strings.Cut(entry, "=")
+		if !ok {
+			return fmt.Errorf("invalid rate limit %q, expected platform=rpm/concurrency", entry)
+		}
+
+		platform, ok := util.CanonicalPlatform(strings.TrimSpace(name))
+		if !ok {
+			return fmt.Errorf("unknown platform %q in rate limits", name)
+		}
+
+		limit := defaults
+		rpm, concurrency, hasConcurrency := strings.Cut(spec, "/")
+		if v, err := strconv.Atoi(strings.TrimSpace(rpm)); err == nil && v >= 0 {
+			limit.RequestsPerMinute = v
+		} else {
+			return fmt.Errorf("invalid requests per minute in rate limit %q", entry)
+		}
+		if hasConcurrency {
+			v, err := strconv.Atoi(strings.TrimSpace(concurrency))
+			if err != nil || v < 0 {
+				return fmt.Errorf("invalid concurrency in rate limit %q", entry)
+			}
+			limit.Concurrency = v
+		}
+
+		overrides[platform] = limit
+	}
+
+	log.Printf("[RATELIMIT] Default %d/min, %d at a time | Overrides=%d",
+		defaults.RequestsPerMinute, defaults.Concurrency, len(overrides))
+	return nil
+}
+
+// Slot is a running request against a platform
+type Slot struct {
+	platform string
+	once     sync.Once
+}
+
+// Acquire waits for a request token and a concurrency slot of platform.
+// waiting is called once, if at all, when the caller has to wait. Platforms
+// DetectPlatform doesn't know are not limited, they may be any site.
+func Acquire(ctx context.Context, platform string, waiting func()) (*Slot, error) {
+	return acquire(ctx, platform, true, waiting)
+}
+
+// Wait only waits for a request token, for runs that must not take a
+// concurrency slot, e.g. live recordings or later attempts of a job
+func Wait(ctx context.Context, platform string, waiting func()) error {
+	_, err := acquire(ctx, platform, false, waiting)
+	return err
+}
+
+func acquire(ctx context.Context, platform string, slot bool, waiting func()) (*Slot, error) {
+	if platform == "" || platform == "Unknown" {
+		return &Slot{}, nil
+	}
+
+	notified := false
+
+	for {
+		mu.Lock()
+		b := bucketLocked(platform)
+		delay, ok := b.takeLocked(time.Now(), slot)
+		freed := b.freed
+		mu.Unlock()
+
+		if ok {
+			if !slot {
+				return nil, nil
+			}
+			return &Slot{platform: platform}, nil
+		}
+
+		if !notified {
+			log.Printf("[RATELIMIT] Waiting for %s capacity", platform)
+			if waiting != nil {
+				waiting()
+			}
+			notified = true
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-freed:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// Release gives the concurrency slot back, calling it again does nothing
+func (s *Slot) Release() {
+	if s == nil || s.platform == "" {
+		return
+	}
+
+	s.once.Do(func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		b := bucketLocked(s.platform)
+		b.active--
+		close(b.freed)
+		b.freed = make(chan struct{})
+	})
+}
+
+// bucketLocked returns the bucket of platform, a new one starts full
+func bucketLocked(platform string) *bucket {
+	b, ok := buckets[platform]
+	if ok {
+		return b
+	}
+
+	limit, ok := overrides[platform]
+	if !ok {
+		limit = defaults
+	}
+
+	b = &bucket{
+		limit:  limit,
+		tokens: float64(burst(limit)),
+		last:   time.Now(),
+		freed:  make(chan struct{}),
+	}
+	buckets[platform] = b
+	return b
+}
+
+// burst is how many requests may go out at once after a quiet period
+func burst(limit Limit) int {
+	return max(limit.RequestsPerMinute/6, 1)
+}
+
+// takeLocked takes a token, and a slot when slot is set. When that isn't
+// possible yet it returns how long to wait before trying again.
+func (b *bucket) takeLocked(now time.Time, slot bool) (time.Duration, bool) {
+	if b.limit.RequestsPerMinute > 0 {
+		rate := float64(b.limit.RequestsPerMinute) / float64(time.Minute)
+		b.tokens = min(b.tokens+float64(now.Sub(b.last))*rate, float64(burst(b.limit)))
+		b.last = now
+	}
+
+	if slot && b.limit.Concurrency > 0 && b.active >= b.limit.Concurrency {
+		// Woken through freed, the delay is only a fallback
+		return 10 * time.Second, false
+	}
+
+	if b.limit.RequestsPerMinute > 0 {
+		if b.tokens < 1 {
+			rate := float64(b.limit.RequestsPerMinute) / float64(time.Minute)
+			return time.Duration((1 - b.tokens) / rate), false
+		}
+		b.tokens--
+	}
+
+	if slot {
+		b.active++
+	}
+	return 0, true
+}
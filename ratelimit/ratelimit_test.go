@@ -0,0 +1,155 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"backend/config"
+)
+
+func setup(t *testing.T, rpm, concurrency int, overrides ...string) {
+	t.Helper()
+	err := Setup(&config.Config{
+		RateLimitRPM:         rpm,
+		RateLimitConcurrency: concurrency,
+		RateLimits:           overrides,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBucketTokens(t *testing.T) {
+	now := time.Now()
+	// 60 a minute, a burst of 10
+	b := &bucket{limit: Limit{RequestsPerMinute: 60}, tokens: 10, last: now}
+
+	for i := 0; i < 10; i++ {
+		if _, ok := b.takeLocked(now, false); !ok {
+			t.Fatalf("token %d of the burst refused", i+1)
+		}
+	}
+
+	delay, ok := b.takeLocked(now, false)
+	if ok {
+		t.Fatal("token taken past the burst")
+	}
+	if delay <= 0 || delay > time.Second {
+		t.Fatalf("delay = %v, want up to a second", delay)
+	}
+
+	if _, ok := b.takeLocked(now.Add(time.Second), false); !ok {
+		t.Fatal("no token a second later")
+	}
+
+	// A long pause refills up to the burst only
+	later := now.Add(time.Hour)
+	for i := 0; i < 10; i++ {
+		if _, ok := b.takeLocked(later, false); !ok {
+			t.Fatalf("token %d after a pause refused", i+1)
+		}
+	}
+	if _, ok := b.takeLocked(later, false); ok {
+		t.Fatal("pause refilled past the burst")
+	}
+}
+
+func TestBucketUnlimited(t *testing.T) {
+	b := &bucket{}
+	for i := 0; i < 100; i++ {
+		if _, ok := b.takeLocked(time.Now(), true); !ok {
+			t.Fatalf("take %d refused without limits", i+1)
+		}
+	}
+}
+
+func TestAcquireConcurrency(t *testing.T) {
+	setup(t, 0, 2)
+	ctx := context.Background()
+
+	first, err := Acquire(ctx, "TikTok", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Acquire(ctx, "TikTok", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both slots are taken
+	short, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	waited := false
+	if _, err := Acquire(short, "TikTok", func() { waited = true }); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("third Acquire: err = %v, want the deadline", err)
+	}
+	if !waited {
+		t.Fatal("waiting callback not called")
+	}
+
+	// Other platforms and token only waits don't need a slot
+	if _, err := Acquire(ctx, "Instagram", nil); err != nil {
+		t.Fatalf("Acquire of another platform: %v", err)
+	}
+	if err := Wait(ctx, "TikTok", nil); err != nil {
+		t.Fatalf("Wait with every slot taken: %v", err)
+	}
+
+	got := make(chan error)
+	go func() {
+		_, err := Acquire(ctx, "TikTok", nil)
+		got <- err
+	}()
+
+	first.Release()
+	// Released twice, the slot only counts once
+	first.Release()
+
+	select {
+	case err := <-got:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("released slot didn't wake the waiting Acquire")
+	}
+
+	mu.Lock()
+	active := buckets["TikTok"].active
+	mu.Unlock()
+	if active != 2 {
+		t.Fatalf("active = %d, want 2", active)
+	}
+}
+
+func TestAcquireUnknownPlatform(t *testing.T) {
+	setup(t, 1, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	for i := 0; i < 5; i++ {
+		if _, err := Acquire(ctx, "Unknown", nil); err != nil {
+			t.Fatalf("Acquire %d of an unknown platform: %v", i+1, err)
+		}
+	}
+}
+
+func TestSetupOverrides(t *testing.T) {
+	setup(t, 30, 4, "tiktok=20/2", "Instagram=10")
+
+	if got := overrides["TikTok"]; got != (Limit{RequestsPerMinute: 20, Concurrency: 2}) {
+		t.Fatalf("TikTok = %+v", got)
+	}
+	// Without a concurrency the default stays
+	if got := overrides["Instagram"]; got != (Limit{RequestsPerMinute: 10, Concurrency: 4}) {
+		t.Fatalf("Instagram = %+v", got)
+	}
+
+	for _, bad := range []string{"TikTok", "Nowhere=10/1", "TikTok=fast", "TikTok=10/-1"} {
+		err := Setup(&config.Config{RateLimits: []string{bad}})
+		if err == nil {
+			t.Fatalf("Setup accepted %q", bad)
+		}
+	}
+}
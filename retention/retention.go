@@ -0,0 +1,361 @@
+package retention
+
+import (
+	"backend/config"
+	"backend/joblog"
+	"backend/jobs"
+	"backend/models"
+	"backend/storage"
+	util "backend/utils"
+	"context"
+	"expvar"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Policy string
+
+const (
+	PolicyCleanupAt     Policy = "cleanup_at"
+	PolicyFixedTTL      Policy = "ttl"
+	PolicyAfterDownload Policy = "after_download"
+)
+
+// Files nobody tracks (crashed jobs, manual copies) are swept after this long
+const orphanAge = 24 * time.Hour
+
+const rootDir = "downloads"
+
+// entry is one finished file and the jobs that hand it out, Key is its storage key
+type entry struct {
+	Key        string
+	Owners     []string
+	Policy     Policy
+	ExpiresAt  time.Time
+	Size       int64
+	LastAccess time.Time
+	Downloaded bool
+}
+
+var (
+	files = make(map[string]*entry)
+	mu    sync.Mutex
+
+	metrics = expvar.NewMap("retention")
+
+	onDelete []func(key string)
+)
+
+// OnDelete registers fn to run with the storage key of every file retention
+// deletes. Register during init.
+func OnDelete(fn func(key string)) {
+	onDelete = append(onDelete, fn)
+}
+
+// Track registers the file of a finished job, expiry follows policy. A
+// file shared by several jobs follows the strictest of their policies.
+func Track(requestID string, result *models.VideoDownloadResult, policy Policy) {
+	if result == nil || result.StorageKey == "" {
+		return
+	}
+
+	info, err := storage.Default().Stat(context.Background(), result.StorageKey)
+	if err != nil {
+		return
+	}
+
+	if policy == "" {
+		policy = Policy(config.Get().RetentionPolicy)
+	}
+
+	expires := time.Unix(result.CleanupAt, 0)
+	if policy == PolicyFixedTTL {
+		expires = info.ModTime.Add(config.Get().RetentionTTL)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if e, ok := files[result.StorageKey]; ok {
+		e.Owners = appendOwner(e.Owners, requestID)
+		if expires.Before(e.ExpiresAt) {
+			e.ExpiresAt = expires
+		}
+		// Downloads before count for the other owners, the new one gets its turn
+		if policy == PolicyAfterDownload && e.Policy != PolicyAfterDownload {
+			e.Policy = PolicyAfterDownload
+			e.Downloaded = false
+		}
+		return
+	}
+
+	files[result.StorageKey] = &entry{
+		Key:        result.StorageKey,
+		Owners:     []string{requestID},
+		Policy:     policy,
+		ExpiresAt:  expires,
+		Size:       info.Size,
+		LastAccess: time.Now(),
+	}
+}
+
+// Touch records an access to key, served in full when complete is set
+func Touch(key string, complete bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	e, ok := files[key]
+	if !ok {
+		return
+	}
+
+	e.LastAccess = time.Now()
+	if complete {
+		e.Downloaded = true
+	}
+}
+
+// Restore rebuilds the tracked files from the persisted job records
+func Restore() {
+	for _, record := range jobs.All() {
+		if record.Status != jobs.StatusCompleted || record.Result == nil {
+			continue
+		}
+
+		policy := Policy("")
+		if record.Request.OriginalReq.OneTimeLink {
+			policy = PolicyAfterDownload
+		}
+		Track(record.ID, record.Result, policy)
+	}
+}
+
+// Start sweeps the downloads directory until ctx is cancelled
+func Start(ctx context.Context) {
+	interval := config.Get().RetentionSweepInterval
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			Sweep()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Sweep deletes expired files, files already downloaded under the
+// after_download policy, then evicts least recently used files while the
+// disk is under pressure. The lock is only held to pick the files, not
+// while the storage backend deletes them.
+func Sweep() {
+	now := time.Now()
+
+	type victim struct {
+		e      *entry
+		reason string
+	}
+
+	mu.Lock()
+	var victims []victim
+	var candidates []*entry
+	for _, e := range files {
+		if inUse(e) {
+			continue
+		}
+
+		switch {
+		case now.After(e.ExpiresAt):
+			victims = append(victims, victim{e, "expired"})
+		case e.Policy == PolicyAfterDownload && e.Downloaded:
+			victims = append(victims, victim{e, "downloaded"})
+		default:
+			candidates = append(candidates, e)
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].LastAccess.Before(candidates[j].LastAccess)
+	})
+	mu.Unlock()
+
+	for _, v := range victims {
+		remove(v.e, v.reason)
+	}
+
+	for _, e := range candidates {
+		if !underPressure() {
+			break
+		}
+		remove(e, "disk_pressure")
+	}
+
+	keep := func(path string) bool {
+		return jobs.IsActiveFile(path) || isTracked(path)
+	}
+	if err := util.DeleteFilesOlderThan(rootDir, orphanAge, keep); err != nil && !os.IsNotExist(err) {
+		log.Printf("[RETENTION] Orphan sweep failed: %v", err)
+	}
+
+	// Logs and records of failed, stopped and expired jobs have no file to go with
+	keepJob := func(id string) bool {
+		return jobs.Get(id) != nil || ownsFile(id)
+	}
+	joblog.DeleteOlderThan(config.Get().JobLogTTL, keepJob)
+	jobs.Prune(config.Get().JobRecordTTL, keepJob)
+}
+
+// ownsFile reports whether a retained file belongs to job id
+func ownsFile(id string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, e := range files {
+		for _, owner := range e.Owners {
+			if owner == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// inUse protects files whose job, or a job attached to it, is still running
+func inUse(e *entry) bool {
+	for _, id := range e.Owners {
+		if jobs.Get(id) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// remove deletes the files of e, unless a job took it up since Sweep picked it
+func remove(e *entry, reason string) {
+	mu.Lock()
+	if files[e.Key] != e || inUse(e) {
+		mu.Unlock()
+		return
+	}
+	delete(files, e.Key)
+	owners := slices.Clone(e.Owners)
+	mu.Unlock()
+
+	// Everything of the job goes, keys are "<requestID>/<file>"
+	prefix := e.Key
+	if i := strings.Index(e.Key, "/"); i > 0 {
+		prefix = e.Key[:i+1]
+	}
+
+	if err := storage.DeletePrefix(context.Background(), storage.Default(), prefix); err != nil {
+		log.Printf("[RETENTION] Failed to delete %s: %v", e.Key, err)
+
+		// Tracked again so the next sweep retries
+		mu.Lock()
+		if _, ok := files[e.Key]; !ok {
+			files[e.Key] = e
+		}
+		mu.Unlock()
+		return
+	}
+
+	metrics.Add("deleted_files", 1)
+	metrics.Add("deleted_bytes", e.Size)
+	metrics.Add("deleted_"+reason, 1)
+
+	for _, id := range owners {
+		jobs.SetStatus(id, jobs.StatusExpired)
+		joblog.Remove(id)
+	}
+	for _, fn := range onDelete {
+		fn(e.Key)
+	}
+
+	log.Printf("[RETENTION] Deleted %s | Reason=%s | Size=%d", e.Key, reason, e.Size)
+}
+
+func underPressure() bool {
+	cfg := config.Get()
+
+	if cfg.RetentionMaxBytes > 0 && TrackedBytes() > cfg.RetentionMaxBytes {
+		return true
+	}
+
+	free, err := util.DiskFree(rootDir)
+	if err != nil {
+		return false
+	}
+	return free < cfg.RetentionMinFreeBytes
+}
+
+// TrackedBytes is the size of every retained file
+func TrackedBytes() int64 {
+	mu.Lock()
+	defer mu.Unlock()
+	return trackedBytesLocked()
+}
+
+// BytesOwnedBy sums the retained files having at least one owner matching fn
+func BytesOwnedBy(fn func(requestID string) bool) int64 {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var total int64
+	for _, e := range files {
+		for _, id := range e.Owners {
+			if fn(id) {
+				total += e.Size
+				break
+			}
+		}
+	}
+	return total
+}
+
+func trackedBytesLocked() int64 {
+	var total int64
+	for _, e := range files {
+		total += e.Size
+	}
+	return total
+}
+
+// isTracked reports whether a path in the local downloads directory holds a tracked file
+func isTracked(path string) bool {
+	rel, err := filepath.Rel(rootDir, path)
+	if err != nil {
+		return false
+	}
+	key := filepath.ToSlash(rel)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for k := range files {
+		if k == key || strings.HasPrefix(k, key+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func appendOwner(owners []string, id string) []string {
+	for _, o := range owners {
+		if o == id {
+			return owners
+		}
+	}
+	return append(owners, id)
+}
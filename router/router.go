@@ -2,7 +2,7 @@ package router
 
 import (
 	controllers "backend/controller"
-	"strings"
+	"expvar"
 
 	"github.com/gin-gonic/gin"
 )
@@ -13,24 +13,28 @@ func SetupRouter() *gin.Engine {
 
 	r.POST("/video", controllers.VideoHandler)
 	r.GET("/stream/:request_id", controllers.SSEHandler)
-
-	r.GET("/downloads/:filename", func(c *gin.Context) {
-		filename := sanitizeFileName(c.Param("filename"))
-		filePath := "./downloads/" + filename
-		c.FileAttachment(filePath, filename)
-	})
+	r.POST("/jobs/:id/stop", controllers.StopJobHandler)
+	r.GET("/jobs/:id/logs", controllers.AdminAuth(), controllers.JobLogsHandler)
+	r.POST("/stream-download", controllers.StreamDownloadHandler)
+	r.POST("/resolve", controllers.ResolveHandler)
+
+	r.GET("/downloads/:id/:filename", controllers.DownloadFileHandler)
+	r.HEAD("/downloads/:id/:filename", controllers.DownloadFileHandler)
+
+	r.GET("/health", controllers.HealthHandler)
+
+	admin := r.Group("/admin", controllers.AdminAuth())
+	admin.GET("/cookies", controllers.ListCookieJarsHandler)
+	admin.POST("/cookies/:platform", controllers.UploadCookieJarHandler)
+	admin.POST("/cookies/:platform/:id/validate", controllers.ValidateCookieJarHandler)
+	admin.DELETE("/cookies/:platform/:id", controllers.DeleteCookieJarHandler)
+	admin.GET("/proxies", controllers.ListProxiesHandler)
+	admin.GET("/dependencies", controllers.DependenciesHandler)
+	admin.POST("/dependencies/yt-dlp/update", controllers.UpdateYTDLPHandler)
+	admin.POST("/dependencies/yt-dlp/rollback", controllers.RollbackYTDLPHandler)
+	admin.GET("/profiles", controllers.ListProfilesHandler)
+	admin.POST("/profiles/reload", controllers.ReloadProfilesHandler)
+	admin.GET("/debug/vars", gin.WrapH(expvar.Handler()))
 
 	return r
 }
-
-func sanitizeFileName(name string) string {
-	if name == "" {
-		return "video"
-	}
-	return strings.Map(func(r rune) rune {
-		if strings.ContainsRune(`<>:"/\|?*`, r) {
-			return -1
-		}
-		return r
-	}, name)
-}
@@ -1,11 +1,16 @@
 package services
 
 import (
+	"backend/downloader"
+	"backend/joblog"
+	"backend/jobs"
 	"backend/models"
+	"backend/pipeline"
+	"backend/proxy"
 	"backend/sse"
 	util "backend/utils"
-	runner "backend/yt-dlp"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -13,21 +18,94 @@ import (
 	"strings"
 )
 
-func DownloadService(req models.DownloadVideoRequest) (*models.VideoDownloadResult, error) {
+// ErrStopped is returned when a download is cancelled through POST /jobs/:id/stop
+var ErrStopped = errors.New("download stopped")
 
-	result, err := downloadWithDynamicCommand(req)
+// DownloadService fetches, checks and stores the file of a job. Everything
+// the tools print goes to the job's log, see GET /jobs/:id/logs.
+func DownloadService(ctx context.Context, req models.DownloadVideoRequest) (*models.VideoDownloadResult, error) {
+	defer proxy.Forget(req.RequestID)
+
+	ctx, jobLog := joblog.Start(ctx, req.RequestID)
+	defer jobLog.Close()
+
+	jobLog.Printf("job %s | URL=%s | Platform=%s | Quality=%s | Live=%v",
+		req.RequestID, req.URL, req.Platform, req.VideoQuality, req.IsLive)
+
+	result, err := download(ctx, req)
 	if err != nil {
+		jobLog.Printf("failed: %v", err)
 		return nil, err
 	}
 
-	if _, err := os.Stat(result.FilePath); err != nil {
-		return nil, fmt.Errorf("final file not found: %w", err)
+	jobLog.Printf("done | File=%s | Key=%s", result.FileName, result.StorageKey)
+	return result, nil
+}
+
+// download runs the job through the pipeline stages, each on a worker of its own pool
+func download(ctx context.Context, req models.DownloadVideoRequest) (*models.VideoDownloadResult, error) {
+	result, err := fetch(ctx, req)
+	if errors.Is(err, downloader.ErrLive) {
+		// Only /live and channel links are checked before, see util.MayBeLive
+		req = recordInstead(req)
+		result, err = fetch(ctx, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.Streams) == 0 {
+		if _, err := os.Stat(result.FilePath); err != nil {
+			return nil, fmt.Errorf("final file not found: %w", err)
+		}
 	}
 
+	// A stopped live recording still has to be finalized and stored
+	finishCtx := ctx
+	if req.IsLive {
+		finishCtx = context.WithoutCancel(ctx)
+	}
+
+	// A single file that stays as it is doesn't queue behind transcodes
+	if req.IsLive || len(result.Streams) > 0 || needsMP3(req, result) {
+		err = runStage(finishCtx, pipeline.Merge, req.RequestID, func() error {
+			if req.IsLive {
+				return finalizeRecording(finishCtx, req, result)
+			}
+			if err := mergeStreams(finishCtx, req, result); err != nil {
+				return err
+			}
+			return extractAudio(finishCtx, req, result)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	finishCtx = context.WithoutCancel(ctx)
+
+	// The probe only adds details, a job that can't get a worker is stored without them
+	err = runStage(finishCtx, pipeline.PostProcess, req.RequestID, func() error {
+		probeResult(finishCtx, req, result)
+		return nil
+	})
+	if err != nil {
+		log.Printf("[DownloadService] Post-processing skipped | RequestID=%s | Error=%v", req.RequestID, err)
+		joblog.Printf(ctx, "post-processing skipped: %v", err)
+	}
+
+	err = runStage(finishCtx, pipeline.Upload, req.RequestID, func() error {
+		result, err = publishResult(finishCtx, req, result)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
 	return result, nil
 }
 
 func downloadWithDynamicCommand(
+	ctx context.Context,
 	request models.DownloadVideoRequest,
 ) (*models.VideoDownloadResult, error) {
 
@@ -36,21 +114,20 @@ func downloadWithDynamicCommand(
 		title = "prodl_" + request.RequestID[:8]
 	}
 
-	// Convert title → ASCII safe slug
+	// ASCII slug on disk, the original title is kept for the download name
 	safeTitle := util.SanitizedFileName(title)
 
-	if err := util.EnsureRootDirectory(); err != nil {
+	jobDir, err := util.EnsureJobDirectory(request.RequestID)
+	if err != nil {
 		return nil, fmt.Errorf("failed to ensure directory: %w", err)
 	}
 
-	ext := "mp3"
-	if !request.OriginalReq.AudioOnly {
-		ext = "mp4"
-	}
+	// Backends pick the extension, e.g. yt-dlp webm or mkv when formats can't go into mp4
+	outputTemplate := filepath.Join(jobDir, safeTitle+".%(ext)s")
 
-	// Safe ASCII filename (no encoding required)
-	fileName := fmt.Sprintf("%s.%s", safeTitle, ext)
-	outputPath := filepath.Join("downloads", fileName)
+	jobs.Update(request.RequestID, func(r *jobs.Record) {
+		r.OutputPath = outputTemplate
+	})
 
 	sse.Send(request.RequestID, map[string]interface{}{
 		"status":  "initializing",
@@ -58,22 +135,62 @@ func downloadWithDynamicCommand(
 		"percent": 0,
 	})
 
-	ctx := context.Background()
+	// Checked at submission too, the address may have changed since
+	if err := downloader.CheckURL(ctx, request.URL); err != nil {
+		return nil, err
+	}
+
+	session, lease := acquireSession(request.URL, request.RequestID)
+
+	backend := pickDownloader(ctx, request, session.Proxy)
+
+	jobs.Update(request.RequestID, func(r *jobs.Record) {
+		r.Downloader = backend.Name()
+	})
+
+	log.Printf("[DownloadService] Downloader=%s | RequestID=%s", backend.Name(), request.RequestID)
+	joblog.Printf(ctx, "downloader %s | Format=%s | Proxy=%v | Cookies=%v",
+		backend.Name(), request.VideoQuality, session.Proxy != "", session.CookiesFile != "" || session.CookiesBrowser != "")
 
-	args := buildYTArgs(request, outputPath)
+	paths, err := backend.Download(ctx, downloader.Job{
+		Request: request,
+		Dir:     jobDir,
+		Name:    safeTitle,
+		Session: session,
+	})
+	lease.Release(ctx, err)
+	if err != nil && ctx.Err() != nil {
+		return nil, ErrStopped
+	}
+	if err != nil {
+		// The error event is sent once the job is finished, with the error code
+		return nil, fmt.Errorf("%s execution failed: %w", backend.Name(), err)
+	}
 
-	log.Printf("[DownloadService] YT-DLP ARGS:\n__\n%s\n__\n", strings.Join(args, " "))
+	if len(paths) > 1 {
+		return &models.VideoDownloadResult{
+			RequestID: request.RequestID,
+			FilePath:  filepath.Join(jobDir, safeTitle+".mp4"),
+			FileName:  util.DisplayFileName(title, "mp4"),
+			Title:     title,
+			Streams:   paths,
+		}, nil
+	}
 
-	err := runner.RunYTDownloadWithProgress(ctx, args, request.RequestID)
+	var finalPath string
+	if len(paths) == 1 {
+		finalPath = paths[0]
+	}
+
+	outputPath, err := placeOutput(finalPath, jobDir, safeTitle)
 	if err != nil {
-		sse.Send(request.RequestID, map[string]interface{}{
-			"status":  "error",
-			"message": "Download failed",
-			"percent": 0,
-		})
-		return nil, fmt.Errorf("yt-dlp execution failed: %w", err)
+		return nil, err
 	}
 
+	jobs.Update(request.RequestID, func(r *jobs.Record) {
+		r.OutputPath = outputPath
+	})
+
 	sse.Send(request.RequestID, map[string]interface{}{
 		"status":  "completed",
 		"message": "Download completed",
@@ -85,51 +202,51 @@ func downloadWithDynamicCommand(
 		return nil, fmt.Errorf("file not found after download: %w", err)
 	}
 
+	ext := strings.TrimPrefix(filepath.Ext(outputPath), ".")
+
 	return &models.VideoDownloadResult{
-		RequestID:   request.RequestID,
-		FilePath:    outputPath,
-		FileName:    fileName,
-		Title:       title,                    // original title for UI
-		DownloadURL: "/downloads/" + fileName, // no encoding needed
-		CleanupAt:   util.EstimateCleanupTime(fileInfo.Size()),
+		RequestID: request.RequestID,
+		FilePath:  outputPath,
+		FileName:  util.DisplayFileName(title, ext),
+		Title:     title, // original title for UI
+		CleanupAt: util.EstimateCleanupTime(fileInfo.Size()),
 	}, nil
 }
 
-func buildYTArgs(
-	request models.DownloadVideoRequest,
-	outputPath string,
-) []string {
-
-	var args []string
-
-	args = append(args,
-		"--no-playlist",
-		"--cookies-from-browser", "firefox",
-		"--newline",
-		"-o", outputPath,
-	)
-
-	if request.OriginalReq.AudioOnly {
-
-		args = append(args,
-			"-f", "bestaudio",
-			"--extract-audio",
-			"--audio-format", "mp3",
-			"--concurrent-fragments", "4",
-		)
-
-	} else {
+// pickDownloader keeps the backend an interrupted run of the job used, its
+// partial files only make sense to that backend
+func pickDownloader(ctx context.Context, request models.DownloadVideoRequest, proxy string) downloader.Downloader {
+	if record := jobs.Lookup(request.RequestID); record != nil && record.Downloader != "" {
+		if backend, ok := downloader.Get(record.Downloader); ok {
+			return backend
+		}
+	}
+	return downloader.Select(ctx, request, proxy)
+}
 
-		fragments := util.GetFragmentsByQuality(request.VideoQuality)
+// placeOutput moves the file the backend reported to <jobDir>/<slug>.<ext>.
+// Without a reported path, e.g. an older yt-dlp, the job directory is searched.
+func placeOutput(finalPath, jobDir, safeTitle string) (string, error) {
+	if finalPath == "" {
+		found, err := util.FindDownloadedFile(jobDir, safeTitle, "")
+		if err != nil {
+			return "", fmt.Errorf("downloader did not report an output file: %w", err)
+		}
+		log.Printf("[DownloadService] No final path reported, found %s", found)
+		finalPath = found
+	}
 
-		args = append(args,
-			"-f", request.VideoQuality,
-			"--concurrent-fragments", fragments,
-			"--merge-output-format", "mp4",
-		)
+	if _, err := os.Stat(finalPath); err != nil {
+		return "", fmt.Errorf("reported output file missing: %w", err)
 	}
 
-	args = append(args, request.URL)
+	outputPath := filepath.Join(jobDir, safeTitle+filepath.Ext(finalPath))
+	if filepath.Clean(finalPath) == outputPath {
+		return outputPath, nil
+	}
 
-	return args
+	if err := os.Rename(finalPath, outputPath); err != nil {
+		return "", fmt.Errorf("failed to move output into place: %w", err)
+	}
+	return outputPath, nil
 }
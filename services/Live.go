@@ -0,0 +1,169 @@
+package services
+
+import (
+	"backend/ffmpeg"
+	"backend/jobs"
+	"backend/models"
+	"backend/sse"
+	util "backend/utils"
+	runner "backend/yt-dlp"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultLiveMaxDuration = 4 * time.Hour
+	MaxLiveMaxDuration     = 12 * time.Hour
+)
+
+// recordInstead turns a job whose video link turned out to be a running
+// stream into a live recording
+func recordInstead(request models.DownloadVideoRequest) models.DownloadVideoRequest {
+	log.Printf("[LiveService] Link is live, recording instead | RequestID=%s", request.RequestID)
+
+	request.IsLive = true
+	request.VideoType = string(models.VideoTypeLive)
+	request.VideoQuality, _ = util.CheckAndPickFormat(request.OriginalReq.Quality, request.VideoType)
+
+	jobs.Update(request.RequestID, func(r *jobs.Record) {
+		r.Request = request
+	})
+	return request
+}
+
+// LiveRecordService records a live stream until it ends, the max duration
+// is reached or ctx is cancelled through POST /jobs/:id/stop.
+func LiveRecordService(ctx context.Context, request models.DownloadVideoRequest) (*models.VideoDownloadResult, error) {
+
+	title := strings.TrimSpace(request.Title)
+	if title == "" {
+		title = "prodl_live_" + request.RequestID[:8]
+	}
+
+	safeTitle := util.SanitizedFileName(title)
+
+	jobDir, err := util.EnsureJobDirectory(request.RequestID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to ensure directory: %w", err)
+	}
+
+	// MPEG-TS stays playable even when the recording is cut off
+	recordingPath := filepath.Join(jobDir, safeTitle+".ts")
+	fileName := util.DisplayFileName(title, "mp4")
+
+	jobs.Update(request.RequestID, func(r *jobs.Record) {
+		r.OutputPath = recordingPath
+	})
+
+	maxDuration := liveMaxDuration(request.OriginalReq.MaxDuration)
+	recordCtx, cancel := context.WithTimeout(ctx, maxDuration)
+	defer cancel()
+
+	sse.Send(request.RequestID, map[string]interface{}{
+		"status":       "initializing",
+		"message":      "Preparing live recording",
+		"percent":      0,
+		"max_duration": int(maxDuration.Seconds()),
+	})
+
+	session, lease := acquireSession(request.URL, request.RequestID)
+
+	args, err := buildLiveArgs(request, recordingPath, session)
+	if err != nil {
+		lease.Discard()
+		return nil, err
+	}
+
+	_, _, err = runner.RunYTDownloadWithProgress(recordCtx, args, request.RequestID, 0)
+	lease.Release(recordCtx, err)
+
+	stopped := recordCtx.Err() != nil
+	if err != nil && !stopped {
+		os.Remove(recordingPath)
+		return nil, fmt.Errorf("yt-dlp execution failed: %w", err)
+	}
+
+	if _, statErr := os.Stat(recordingPath); statErr != nil {
+		return nil, fmt.Errorf("recording not found after stop: %w", statErr)
+	}
+
+	log.Printf("[LiveService] Recording ended | RequestID=%s | Stopped=%v", request.RequestID, stopped)
+
+	// The MP4 is made by finalizeRecording on a merge worker
+	return &models.VideoDownloadResult{
+		RequestID: request.RequestID,
+		FilePath:  recordingPath,
+		FileName:  fileName,
+		Title:     title,
+	}, nil
+}
+
+// finalizeRecording remuxes the MPEG-TS recording of result into an MP4.
+// ctx must outlive the stop request that ended the recording.
+func finalizeRecording(ctx context.Context, request models.DownloadVideoRequest, result *models.VideoDownloadResult) error {
+	sse.Send(request.RequestID, map[string]interface{}{
+		"status":  "finalizing",
+		"message": "Finalizing recording",
+		"percent": 100,
+	})
+
+	recordingPath := result.FilePath
+	outputPath := strings.TrimSuffix(recordingPath, filepath.Ext(recordingPath)) + ".mp4"
+
+	if err := ffmpeg.RemuxToMP4(ctx, recordingPath, outputPath); err != nil {
+		return fmt.Errorf("failed to finalize recording: %w", err)
+	}
+	os.Remove(recordingPath)
+
+	fileInfo, err := os.Stat(outputPath)
+	if err != nil {
+		return fmt.Errorf("file not found after finalize: %w", err)
+	}
+
+	jobs.Update(request.RequestID, func(r *jobs.Record) {
+		r.OutputPath = outputPath
+	})
+
+	result.FilePath = outputPath
+	result.CleanupAt = util.EstimateCleanupTime(fileInfo.Size())
+	return nil
+}
+
+func liveMaxDuration(seconds int) time.Duration {
+	if seconds <= 0 {
+		return DefaultLiveMaxDuration
+	}
+
+	d := time.Duration(seconds) * time.Second
+	if d > MaxLiveMaxDuration {
+		return MaxLiveMaxDuration
+	}
+	return d
+}
+
+func buildLiveArgs(
+	request models.DownloadVideoRequest,
+	outputPath string,
+	session runner.Session,
+) ([]string, error) {
+
+	cmd := runner.NewCommand(request.URL).
+		Session(session).
+		Progress().
+		NoPart().
+		HLSUseMpegTS().
+		Format(util.PickLiveFormat(request.OriginalReq.Quality)).
+		Output(outputPath)
+
+	// Only works when the platform keeps a DVR window for the stream
+	if request.OriginalReq.LiveFromStart {
+		cmd.LiveFromStart()
+	}
+
+	return cmd.Build()
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"backend/models"
+	util "backend/utils"
+	runner "backend/yt-dlp"
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ResolveService returns the direct media URLs yt-dlp would download for the
+// request, nothing is downloaded and no download slot is taken
+func ResolveService(ctx context.Context, req models.Request) (*models.ResolvedMedia, error) {
+	if err := lookupToken(ctx, req.URL); err != nil {
+		return nil, err
+	}
+	session, lease := acquireSession(req.URL, "")
+	media, err := runner.ResolveFormats(ctx, req.URL, formatChain(req.URL, req.Quality, req.AudioOnly), session)
+	lease.Release(ctx, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve formats: %w", err)
+	}
+
+	for i := range media.Formats {
+		expires := expiryHint(media.Formats[i].URL)
+		media.Formats[i].ExpiresAt = expires
+
+		if expires > 0 && (media.ExpiresAt == 0 || expires < media.ExpiresAt) {
+			media.ExpiresAt = expires
+		}
+	}
+
+	return media, nil
+}
+
+// formatChain is the yt-dlp format selector used for a request, the same chain
+// DownloadService would use
+func formatChain(videoURL, quality string, audioOnly bool) string {
+	if audioOnly {
+		return "ba/b"
+	}
+
+	platformInfo := util.DetectPlatform(videoURL)
+	format, _ := util.CheckAndPickFormat(quality, string(platformInfo.VideoType))
+	return format
+}
+
+// expiryHint reads the expiry CDNs put into signed media URLs, 0 when there is none
+func expiryHint(rawURL string) int64 {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return 0
+	}
+	query := u.Query()
+
+	// googlevideo.com (YouTube) and most CDNs: unix seconds
+	for _, key := range []string{"expire", "expires", "Expires"} {
+		if v, err := strconv.ParseInt(query.Get(key), 10, 64); err == nil && v > 0 {
+			return v
+		}
+	}
+
+	// Facebook and Instagram CDNs: unix seconds in hex
+	if v, err := strconv.ParseInt(query.Get("oe"), 16, 64); err == nil && v > 0 {
+		return v
+	}
+
+	// S3 presigned URLs: signing time plus lifetime
+	if date, err := time.Parse("20060102T150405Z", query.Get("X-Amz-Date")); err == nil {
+		if ttl, err := strconv.ParseInt(query.Get("X-Amz-Expires"), 10, 64); err == nil {
+			return date.Unix() + ttl
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"backend/config"
+	"backend/ffmpeg"
+	"backend/models"
+	runner "backend/yt-dlp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrStreamTooLong is returned for videos above STREAM_MAX_DURATION
+	ErrStreamTooLong = errors.New("video too long to stream")
+	// ErrStreamLive is returned for live streams, they have no end to stream to
+	ErrStreamLive = errors.New("live streams can't be streamed directly")
+)
+
+// ResolveStream picks the formats for a direct stream and checks the video is short enough
+func ResolveStream(ctx context.Context, req models.StreamVideoDownloadRequest) (*models.ResolvedMedia, error) {
+	if err := lookupToken(ctx, req.URL); err != nil {
+		return nil, err
+	}
+	session, lease := acquireSession(req.URL, "")
+	media, err := runner.ResolveFormats(ctx, req.URL, formatChain(req.URL, req.Quality, req.AudioOnly), session)
+	lease.Release(ctx, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve formats: %w", err)
+	}
+
+	if media.IsLive {
+		return nil, ErrStreamLive
+	}
+
+	limit := config.Get().StreamMaxDuration
+	if media.Duration <= 0 || time.Duration(media.Duration*float64(time.Second)) > limit {
+		return nil, fmt.Errorf("%w: %.0fs, limit is %s", ErrStreamTooLong, media.Duration, limit)
+	}
+
+	return media, nil
+}
+
+// StreamService writes the media to w as it is fetched, nothing is stored on disk.
+// A yt-dlp per format writes into a pipe and ffmpeg muxes the pipes, so the
+// media is fetched with the same cookies, headers and proxy as downloads.
+// Cancelling ctx kills them.
+func StreamService(ctx context.Context, req models.StreamVideoDownloadRequest, media *models.ResolvedMedia, w io.Writer) (err error) {
+	log.Printf("[StreamService] Streaming | Title=%s | Formats=%d | AudioOnly=%v",
+		media.Title, len(media.Formats), req.AudioOnly)
+
+	session, lease := acquireSession(req.URL, "")
+	defer func() { lease.Release(ctx, err) }()
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		fetchErr error
+		inputs   []*os.File
+	)
+	defer func() {
+		for _, r := range inputs {
+			r.Close()
+		}
+	}()
+
+	for _, format := range media.Formats {
+		// Every yt-dlp run is a request to the platform
+		if err := lookupToken(streamCtx, req.URL); err != nil {
+			cancel()
+			wg.Wait()
+			return err
+		}
+
+		r, pw, err := os.Pipe()
+		if err != nil {
+			cancel()
+			wg.Wait()
+			return err
+		}
+		inputs = append(inputs, r)
+
+		wait, err := runner.StreamFormat(streamCtx, req.URL, format.FormatID, session, pw)
+		// yt-dlp holds its own copy, ffmpeg sees EOF once it exits
+		pw.Close()
+		if err != nil {
+			cancel()
+			wg.Wait()
+			return fmt.Errorf("failed to start yt-dlp: %w", err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := wait(); err != nil {
+				// Killed because ffmpeg failed or the client left, that error says more
+				mu.Lock()
+				if fetchErr == nil && streamCtx.Err() == nil {
+					fetchErr = err
+				}
+				mu.Unlock()
+				cancel()
+			}
+		}()
+	}
+
+	err = ffmpeg.StreamMedia(streamCtx, inputs, req.AudioOnly, w)
+	if err != nil {
+		cancel()
+	}
+	wg.Wait()
+
+	switch {
+	case ctx.Err() != nil:
+		return ErrStopped
+	case fetchErr != nil:
+		return fetchErr
+	}
+	return err
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"backend/models"
+	"backend/retention"
+	"backend/storage"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheEntry tracks one download shared by every identical request
+type cacheEntry struct {
+	primaryID string
+	followers []string
+	result    *models.VideoDownloadResult
+	done      bool
+
+	cancel         func() // stops the running download
+	primaryStopped bool   // the primary request left, the download runs on for followers
+	cancelled      bool   // every request left, the download is stopping
+}
+
+var (
+	contentCache = make(map[string]*cacheEntry)
+	cacheMu      sync.Mutex
+)
+
+func init() {
+	retention.OnDelete(forgetStored)
+}
+
+// CacheKey identifies the content a request produces: the canonical URL,
+// the resolved format chain, the audio options and the clip range
+func CacheKey(req models.DownloadVideoRequest) string {
+	audio := "video"
+	if req.OriginalReq.AudioOnly {
+		audio = "audio:mp3"
+	}
+
+	parts := []string{
+		strings.TrimSpace(req.URL),
+		req.VideoQuality,
+		audio,
+		req.OriginalReq.ClipStart + "-" + req.OriginalReq.ClipEnd,
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(parts, "|")))
+	return hex.EncodeToString(sum[:])
+}
+
+// ClaimDownload registers req as the owner of its content. When an
+// identical download is already running, req is attached to it and the
+// primary request ID is returned. When the content was already downloaded
+// and is still retained, the finished result is returned instead.
+func ClaimDownload(req models.DownloadVideoRequest) (primaryID string, result *models.VideoDownloadResult) {
+	key := CacheKey(req)
+
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	entry, ok := contentCache[key]
+	if ok && entry.cancelled {
+		ok = false
+	}
+	if ok && entry.done {
+		if stillRetained(entry.result) {
+			return entry.primaryID, ShareResult(entry.result, req)
+		}
+		ok = false
+	}
+
+	if ok {
+		entry.followers = append(entry.followers, req.RequestID)
+		return entry.primaryID, nil
+	}
+
+	contentCache[key] = &cacheEntry{primaryID: req.RequestID}
+	return "", nil
+}
+
+// SetDownloadCancel stores how to stop the running download of req, the
+// primary of its content
+func SetDownloadCancel(req models.DownloadVideoRequest, cancel func()) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	if entry, ok := contentCache[CacheKey(req)]; ok && entry.primaryID == req.RequestID {
+		entry.cancel = cancel
+	}
+}
+
+// StopDownload takes requestID off the shared download of req. The download
+// itself is only cancelled once no request is attached to it any more. It
+// returns false when requestID has no part in a running shared download.
+func StopDownload(req models.DownloadVideoRequest, requestID string) bool {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	entry, ok := contentCache[CacheKey(req)]
+	if !ok || entry.done {
+		return false
+	}
+
+	if requestID == entry.primaryID {
+		if entry.primaryStopped {
+			return false
+		}
+		entry.primaryStopped = true
+	} else {
+		i := slices.Index(entry.followers, requestID)
+		if i < 0 {
+			return false
+		}
+		entry.followers = slices.Delete(entry.followers, i, i+1)
+	}
+
+	if len(entry.followers) == 0 && entry.primaryStopped && entry.cancel != nil {
+		entry.cancelled = true
+		entry.cancel()
+	}
+	return true
+}
+
+// ReleaseDownload records the outcome of the primary download and returns
+// the request IDs that were attached to it. Failed downloads are dropped
+// from the cache so the next request retries.
+func ReleaseDownload(req models.DownloadVideoRequest, result *models.VideoDownloadResult) []string {
+	key := CacheKey(req)
+
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	entry, ok := contentCache[key]
+	if !ok || entry.primaryID != req.RequestID {
+		return nil
+	}
+
+	followers := entry.followers
+	entry.followers = nil
+	entry.cancel = nil
+
+	if result == nil {
+		delete(contentCache, key)
+		return followers
+	}
+
+	entry.result = result
+	entry.done = true
+	return followers
+}
+
+// forgetStored drops the finished downloads of a file retention deleted
+func forgetStored(storageKey string) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	for key, entry := range contentCache {
+		if entry.done && entry.result != nil && entry.result.StorageKey == storageKey {
+			delete(contentCache, key)
+		}
+	}
+}
+
+func stillRetained(result *models.VideoDownloadResult) bool {
+	if result == nil || time.Now().Unix() >= result.CleanupAt {
+		return false
+	}
+	_, err := storage.Default().Stat(context.Background(), result.StorageKey)
+	return err == nil
+}
+
+// ShareResult copies a finished result for another request with its own
+// download link, so one time links are never handed to two users
+func ShareResult(result *models.VideoDownloadResult, req models.DownloadVideoRequest) *models.VideoDownloadResult {
+	if result == nil {
+		return nil
+	}
+
+	shared := *result
+
+	url, err := storage.Default().SignedURL(context.Background(), result.StorageKey, result.FileName, time.Unix(result.CleanupAt, 0), req.OriginalReq.OneTimeLink)
+	if err != nil {
+		log.Printf("[Cache] Failed to sign shared link | RequestID=%s | Error=%v", req.RequestID, err)
+		return &shared
+	}
+	shared.DownloadURL = url
+	return &shared
+}
@@ -2,7 +2,9 @@ package services
 
 import (
 	"backend/models"
+	util "backend/utils"
 	ytdlp "backend/yt-dlp"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,25 +13,57 @@ import (
 	"time"
 )
 
-func GetVideoInfoService(videoURL string, VideoType string) (*models.VideoInfo, error) {
-
+// GetVideoInfoService fetches metadata from Iframely, falling back to yt-dlp.
+// Cancelling ctx, e.g. when the client goes away, stops the yt-dlp run.
+func GetVideoInfoService(ctx context.Context, videoURL string, VideoType string) (*models.VideoInfo, error) {
 	log.Println("[InfoService] Using Iframely")
 
 	info, err := getInfoFromIframly(videoURL)
 	if err == nil && info.Title != "" {
 		info.Source = "iframely"
+		if util.MayBeLive(videoURL) {
+			addLiveStatus(ctx, videoURL, info)
+		}
 		return info, nil
 	}
 
 	log.Println("[InfoService] Using Yt-DLP")
 
-	info, err = ytdlp.GetVideoInfoFromYTDLP(videoURL)
+	// Iframely fetches from its own server, only our yt-dlp runs count against the platform rate
+	if err := lookupToken(ctx, videoURL); err != nil {
+		return nil, err
+	}
+	session, lease := acquireSession(videoURL, "")
+	info, err = ytdlp.GetVideoInfoFromYTDLP(ctx, videoURL, session)
+	lease.Release(ctx, err)
 	if err == nil {
 		info.Source = "yt-dlp"
 		return info, nil
 	}
 
-	return nil, errors.New("all sources failed to fetch video info")
+	// The yt-dlp error says why, e.g. a private or removed video
+	return nil, fmt.Errorf("all sources failed to fetch video info: %w", err)
+}
+
+// addLiveStatus asks yt-dlp whether videoURL is streaming right now, Iframely
+// can't tell. Without an answer info keeps an empty LiveStatus.
+func addLiveStatus(ctx context.Context, videoURL string, info *models.VideoInfo) {
+	if err := lookupToken(ctx, videoURL); err != nil {
+		return
+	}
+	session, lease := acquireSession(videoURL, "")
+	ytInfo, err := ytdlp.GetVideoInfoFromYTDLP(ctx, videoURL, session)
+	lease.Release(ctx, err)
+	if err != nil {
+		log.Printf("[InfoService] Live status unknown | URL=%s | Error=%v", videoURL, err)
+		return
+	}
+
+	info.IsLive = ytInfo.IsLive
+	info.LiveStatus = ytInfo.LiveStatus
+	if info.Duration == 0 {
+		info.Duration = ytInfo.Duration
+	}
 }
 
 func getInfoFromIframly(videoURL string) (*models.VideoInfo, error) {
@@ -51,12 +85,13 @@ func getInfoFromIframly(videoURL string) (*models.VideoInfo, error) {
 
 	var raw struct {
 		Meta struct {
-			Title       string `json:"title"`
-			Author      string `json:"author"`
-			AuthorURL   string `json:"author_url"`
-			Site        string `json:"site"`
-			Description string `json:"description"`
-			Canonical   string `json:"canonical"`
+			Title       string  `json:"title"`
+			Author      string  `json:"author"`
+			AuthorURL   string  `json:"author_url"`
+			Site        string  `json:"site"`
+			Description string  `json:"description"`
+			Canonical   string  `json:"canonical"`
+			Duration    float64 `json:"duration"`
 		} `json:"meta"`
 		Links []struct {
 			Href string   `json:"href"`
@@ -92,5 +127,6 @@ func getInfoFromIframly(videoURL string) (*models.VideoInfo, error) {
 		Thumbnail: thumb,
 		VideoPage: videoURL,
 		Views:     0,
+		Duration:  raw.Meta.Duration,
 	}, nil
 }
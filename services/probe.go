@@ -0,0 +1,53 @@
+package services
+
+import (
+	"backend/ffmpeg"
+	"backend/models"
+	util "backend/utils"
+	"context"
+	"fmt"
+	"log"
+	"os"
+)
+
+// probeResult fills result.Media from the local file and warns when the
+// fallback chain ended up below the requested quality. A failed probe only
+// costs the details, never the download.
+func probeResult(ctx context.Context, request models.DownloadVideoRequest, result *models.VideoDownloadResult) {
+	probe, err := ffmpeg.Probe(ctx, result.FilePath)
+	if err != nil {
+		log.Printf("[Probe] Failed | RequestID=%s | Error=%v", request.RequestID, err)
+		probe = &models.MediaProbe{}
+	}
+
+	checksum, err := util.FileSHA256(result.FilePath)
+	if err != nil {
+		log.Printf("[Probe] Checksum failed | RequestID=%s | Error=%v", request.RequestID, err)
+	}
+	probe.SHA256 = checksum
+
+	if info, err := os.Stat(result.FilePath); err == nil {
+		probe.FileSize = info.Size()
+	}
+
+	result.Media = probe
+
+	if request.OriginalReq.AudioOnly || probe.Height == 0 {
+		return
+	}
+
+	// Qualities name the short side of a 16:9 frame. Other shapes reach one
+	// when either side does: 1920x800, 1440x1080 and 1080x1920 are 1080p.
+	long, short := max(probe.Width, probe.Height), min(probe.Width, probe.Height)
+	if short == 0 {
+		short = probe.Height
+	}
+	actual := max(short, long*9/16)
+	if requested := util.QualityHeight(request.OriginalReq.Quality); requested > 0 && actual < requested {
+		result.Warnings = append(result.Warnings, fmt.Sprintf(
+			"quality downgraded: requested %s, got %dp", request.OriginalReq.Quality, actual,
+		))
+		log.Printf("[Probe] Quality downgraded | RequestID=%s | Requested=%s | Got=%dx%d",
+			request.RequestID, request.OriginalReq.Quality, probe.Width, probe.Height)
+	}
+}
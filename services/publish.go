@@ -0,0 +1,68 @@
+package services
+
+import (
+	"backend/models"
+	"backend/sse"
+	"backend/storage"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// publishResult hands the downloaded file to the storage backend and signs its download link
+func publishResult(
+	ctx context.Context,
+	request models.DownloadVideoRequest,
+	result *models.VideoDownloadResult,
+) (*models.VideoDownloadResult, error) {
+
+	backend := storage.Default()
+	key := request.RequestID + "/" + filepath.Base(result.FilePath)
+
+	_, local := backend.(*storage.Local)
+	if !local {
+		sse.Send(request.RequestID, map[string]interface{}{
+			"status":  "uploading",
+			"message": "Uploading to storage",
+			"percent": 100,
+		})
+
+		if err := uploadFile(ctx, backend, key, result.FilePath); err != nil {
+			return nil, fmt.Errorf("storage upload failed: %w", err)
+		}
+
+		// The local copy was only a staging area
+		os.RemoveAll(filepath.Dir(result.FilePath))
+		result.FilePath = key
+
+		log.Printf("[Storage] Uploaded | RequestID=%s | Key=%s", request.RequestID, key)
+	}
+
+	result.StorageKey = key
+
+	url, err := backend.SignedURL(ctx, key, result.FileName, time.Unix(result.CleanupAt, 0), request.OriginalReq.OneTimeLink)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign download url: %w", err)
+	}
+	result.DownloadURL = url
+
+	return result, nil
+}
+
+func uploadFile(ctx context.Context, backend storage.Storage, key, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	return backend.Put(ctx, key, f, info.Size())
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"backend/config"
+	"backend/joblog"
+	"backend/models"
+	"backend/proxy"
+	"backend/ratelimit"
+	"backend/sse"
+	util "backend/utils"
+	runner "backend/yt-dlp"
+	"context"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+const maxRetryDelay = 2 * time.Minute
+
+// downloadWithRetry retries failed downloads as far as the error allows,
+// every attempt after the first is announced with a "retrying" event
+func downloadWithRetry(ctx context.Context, request models.DownloadVideoRequest) (*models.VideoDownloadResult, error) {
+	maxAttempts := max(config.Get().RetryMaxAttempts, 1)
+
+	for attempt := 1; ; attempt++ {
+		// The first attempt got its request token with the job's platform slot
+		if attempt > 1 {
+			if err := ratelimit.Wait(ctx, request.Platform, nil); err != nil {
+				return nil, ErrStopped
+			}
+		}
+
+		result, err := downloadWithDynamicCommand(ctx, request)
+		if err == nil || errors.Is(err, ErrStopped) || attempt >= maxAttempts {
+			return result, err
+		}
+
+		next, reason, ok := retryPlan(request, err)
+		if !ok {
+			return nil, err
+		}
+
+		delay := retryDelay(err, attempt)
+
+		log.Printf("[DownloadService] Retrying | RequestID=%s | Attempt=%d/%d | Code=%s | Delay=%s | Format=%s | Error=%v",
+			request.RequestID, attempt+1, maxAttempts, runner.CodeOf(err), delay, next.VideoQuality, err)
+		joblog.Printf(ctx, "attempt %d failed, retrying in %s | Code=%s | Format=%s | Error=%v",
+			attempt, delay, runner.CodeOf(err), next.VideoQuality, err)
+
+		sse.Send(request.RequestID, map[string]interface{}{
+			"status":       "retrying",
+			"message":      reason,
+			"percent":      0,
+			"attempt":      attempt + 1,
+			"max_attempts": maxAttempts,
+			"delay":        int(delay.Seconds()),
+			"code":         runner.CodeOf(err),
+		})
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ErrStopped
+		}
+
+		request = next
+	}
+}
+
+// retryPlan decides whether err is worth another attempt and what to change for it
+func retryPlan(request models.DownloadVideoRequest, err error) (models.DownloadVideoRequest, string, bool) {
+	var ytErr *runner.Error
+	if !errors.As(err, &ytErr) {
+		// Not a yt-dlp failure, e.g. the output file went missing
+		return request, "", false
+	}
+
+	switch ytErr.Code {
+	case runner.CodeRateLimited, runner.CodeNetwork, runner.CodeLoginRequired:
+		// A sticky proxy is dropped so the job moves to another one, the
+		// cookie jar rotates to the least recently used on its own
+		proxy.Forget(request.RequestID)
+		return request, "Connection problem, retrying...", true
+
+	case runner.CodeFormatUnavailable:
+		return stepDown(request), "Quality not available, retrying with a fallback format...", true
+
+	case runner.CodeUnknown:
+		// A format the CDN refuses won't work the next time either
+		if fragmentForbidden(ytErr.Detail) {
+			proxy.Forget(request.RequestID)
+			return stepDown(request), "Download failed, retrying with a fallback format...", true
+		}
+		return request, "Download failed, retrying...", true
+	}
+
+	// The rest won't change on another attempt, CodeDiskFull included:
+	// retrying would only fill the disk again. A missing ffmpeg fails the
+	// merge stage, yt-dlp itself never merges.
+	return request, "", false
+}
+
+// stepDown moves a video job one step down its format chain
+func stepDown(request models.DownloadVideoRequest) models.DownloadVideoRequest {
+	if !request.OriginalReq.AudioOnly {
+		request.VideoQuality = util.StepDownFormat(request.VideoQuality)
+	}
+	return request
+}
+
+// fragmentForbidden reports a 403 on the media itself, e.g. on its
+// fragments, rather than on the page
+func fragmentForbidden(detail string) bool {
+	detail = strings.ToLower(detail)
+	return strings.Contains(detail, "http error 403") &&
+		(strings.Contains(detail, "fragment") || strings.Contains(detail, "video data"))
+}
+
+// retryDelay grows exponentially with the attempt, rate limits start higher
+func retryDelay(err error, attempt int) time.Duration {
+	delay := config.Get().RetryBaseDelay << (attempt - 1)
+	if runner.CodeOf(err) == runner.CodeRateLimited {
+		delay *= 4
+	}
+	if delay > maxRetryDelay || delay <= 0 {
+		delay = maxRetryDelay
+	}
+
+	// Jitter so jobs that failed together don't retry together
+	return delay/2 + rand.N(delay/2+1)
+}
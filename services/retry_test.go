@@ -0,0 +1,90 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"backend/config"
+	"backend/models"
+	runner "backend/yt-dlp"
+)
+
+func TestRetryPlan(t *testing.T) {
+	const chain = "bv*[height<=720]+ba/b[height<=720]/bv*+ba/b"
+	const stepped = "b[height<=720]/bv*+ba/b"
+
+	video := models.DownloadVideoRequest{RequestID: "0123456789abcdef", VideoQuality: chain}
+	audio := video
+	audio.OriginalReq.AudioOnly = true
+
+	exit := errors.New("exit status 1")
+	classified := func(output string) error {
+		return fmt.Errorf("yt-dlp execution failed: %w", runner.Classify(exit, output))
+	}
+
+	tests := []struct {
+		name    string
+		request models.DownloadVideoRequest
+		err     error
+		retry   bool
+		format  string
+	}{
+		{"rate limited", video, classified("ERROR: HTTP Error 429: Too Many Requests"), true, chain},
+		{"network", video, classified("ERROR: Connection reset by peer"), true, chain},
+		{"login wall", video, classified("ERROR: Sign in to confirm you’re not a bot"), true, chain},
+		{"format unavailable", video, classified("ERROR: Requested format is not available"), true, stepped},
+		{"format unavailable audio", audio, classified("ERROR: Requested format is not available"), true, chain},
+		{"fragment forbidden", video, classified("ERROR: fragment 1 not found, unable to continue; HTTP Error 403: Forbidden"), true, stepped},
+		{"unknown", video, classified("ERROR: something new"), true, chain},
+		{"private", video, classified("ERROR: Private video"), false, chain},
+		{"disk full", video, classified("ERROR: [Errno 28] No space left on device"), false, chain},
+		{"too large", video, classified("[download] File is larger than max-filesize"), false, chain},
+		{"not a yt-dlp error", video, errors.New("final file not found"), false, chain},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next, reason, retry := retryPlan(tt.request, tt.err)
+			if retry != tt.retry {
+				t.Fatalf("retry = %v, want %v", retry, tt.retry)
+			}
+			if retry && reason == "" {
+				t.Fatal("retry without a reason for the client")
+			}
+			if next.VideoQuality != tt.format {
+				t.Fatalf("format = %q, want %q", next.VideoQuality, tt.format)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	base := config.Get().RetryBaseDelay
+	network := runner.NewError(runner.CodeNetwork, errors.New("exit status 1"))
+	limited := runner.NewError(runner.CodeRateLimited, errors.New("exit status 1"))
+
+	tests := []struct {
+		name    string
+		err     error
+		attempt int
+		max     time.Duration
+	}{
+		{"first", network, 1, base},
+		{"doubled", network, 3, 4 * base},
+		{"rate limited", limited, 1, 4 * base},
+		{"capped", network, 30, maxRetryDelay},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for range 50 {
+				delay := retryDelay(tt.err, tt.attempt)
+				if delay < tt.max/2 || delay > tt.max {
+					t.Fatalf("delay = %v, want between %v and %v", delay, tt.max/2, tt.max)
+				}
+			}
+		})
+	}
+}
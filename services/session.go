@@ -0,0 +1,63 @@
+package services
+
+import (
+	"backend/config"
+	"backend/cookies"
+	"backend/profiles"
+	"backend/proxy"
+	"backend/ratelimit"
+	util "backend/utils"
+	runner "backend/yt-dlp"
+	"context"
+)
+
+// sessionLease holds the cookie jar and proxy picked for one yt-dlp run
+type sessionLease struct {
+	cookies *cookies.Lease
+	proxy   *proxy.Lease
+}
+
+// acquireSession picks the cookie jar, proxy and profile for the platform of videoURL,
+// jobID keeps a sticky proxy across the runs of one job ("" for none). The
+// lease must be released with the outcome of the run.
+func acquireSession(videoURL, jobID string) (runner.Session, *sessionLease) {
+	platform := util.DetectPlatform(videoURL).Platform
+
+	lease := &sessionLease{
+		cookies: cookies.Acquire(platform),
+		proxy:   proxy.Pick(platform, jobID),
+	}
+
+	session := runner.Session{
+		CookiesBrowser: config.Get().CookiesFromBrowser,
+		Proxy:          lease.proxy.URL,
+		Profile:        profiles.For(platform),
+	}
+	if lease.cookies.Path != "" {
+		session.CookiesFile = lease.cookies.Path
+	}
+
+	return session, lease
+}
+
+// Release reports the outcome, a run cut short through ctx says nothing about the jar or proxy
+func (l *sessionLease) Release(ctx context.Context, runErr error) {
+	if ctx.Err() != nil {
+		runErr = context.Canceled
+	}
+	l.cookies.Release(runErr)
+	l.proxy.Release(runErr)
+}
+
+// Discard cleans up a lease that was never used for a run
+func (l *sessionLease) Discard() {
+	l.cookies.Release(context.Canceled)
+	l.proxy.Release(context.Canceled)
+}
+
+// lookupToken waits for a request token of the platform of videoURL before
+// a yt-dlp metadata lookup. Lookups are short, they don't take one of the
+// concurrency slots downloads queue for.
+func lookupToken(ctx context.Context, videoURL string) error {
+	return ratelimit.Wait(ctx, util.DetectPlatform(videoURL).Platform, nil)
+}
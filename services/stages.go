@@ -0,0 +1,196 @@
+package services
+
+import (
+	"backend/ffmpeg"
+	"backend/joblog"
+	"backend/jobs"
+	"backend/models"
+	"backend/pipeline"
+	"backend/ratelimit"
+	"backend/sse"
+	util "backend/utils"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// queuedMessages tell the client what a job waits for
+var queuedMessages = map[pipeline.Stage]string{
+	pipeline.Fetch:       "Too many downloads. Waiting for slot...",
+	pipeline.Merge:       "Waiting for a free converter...",
+	pipeline.PostProcess: "Waiting to check the file...",
+	pipeline.Upload:      "Waiting for an upload slot...",
+}
+
+// runStage runs fn on a worker of stage. A job stopped while it queues gets ErrStopped.
+func runStage(ctx context.Context, stage pipeline.Stage, requestID string, fn func() error) error {
+	jobs.Update(requestID, func(r *jobs.Record) {
+		r.Stage = string(stage)
+	})
+
+	worker, err := pipeline.Acquire(ctx, stage, func() {
+		sse.Send(requestID, map[string]interface{}{
+			"status":  "queued",
+			"message": queuedMessages[stage],
+			"percent": 0,
+			"stage":   stage,
+		})
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return ErrStopped
+		}
+		return err
+	}
+	defer worker.Release()
+
+	joblog.Printf(ctx, "stage %s", stage)
+	return fn()
+}
+
+// fetch downloads the file of a job once its platform has capacity and a
+// fetch worker is free. Live recordings wait in their own pool instead.
+func fetch(ctx context.Context, request models.DownloadVideoRequest) (*models.VideoDownloadResult, error) {
+	// Waiting here instead of on a worker keeps one busy platform from
+	// holding up the others
+	platformWaiting := func() {
+		sse.Send(request.RequestID, map[string]interface{}{
+			"status":  "queued",
+			"message": "Waiting for platform capacity...",
+			"percent": 0,
+		})
+	}
+
+	if request.IsLive {
+		// A recording would hold a platform slot for hours, it only waits for its request
+		if err := ratelimit.Wait(ctx, request.Platform, platformWaiting); err != nil {
+			return nil, ErrStopped
+		}
+
+		if util.LiveSlotsFull() {
+			sse.Send(request.RequestID, map[string]interface{}{
+				"status":  "queued",
+				"message": "Too many live recordings. Waiting for slot...",
+				"percent": 0,
+			})
+		}
+
+		util.AcquireLiveSlot()
+		defer util.ReleaseLiveSlot()
+
+		started(request.RequestID)
+		return LiveRecordService(ctx, request)
+	}
+
+	slot, err := ratelimit.Acquire(ctx, request.Platform, platformWaiting)
+	if err != nil {
+		return nil, ErrStopped
+	}
+	defer slot.Release()
+
+	var result *models.VideoDownloadResult
+	err = runStage(ctx, pipeline.Fetch, request.RequestID, func() error {
+		started(request.RequestID)
+
+		var err error
+		result, err = downloadWithRetry(ctx, request)
+		return err
+	})
+	return result, err
+}
+
+// started marks the job running once it got its download slot
+func started(requestID string) {
+	jobs.SetStatus(requestID, jobs.StatusRunning)
+
+	sse.Send(requestID, map[string]interface{}{
+		"status":  "start",
+		"message": "Download started",
+		"percent": 0,
+	})
+}
+
+// mergeStreams joins the video and audio the downloader fetched separately
+// into the MP4 of the job, on a merge worker instead of the fetch worker
+func mergeStreams(ctx context.Context, request models.DownloadVideoRequest, result *models.VideoDownloadResult) error {
+	if len(result.Streams) == 0 {
+		return nil
+	}
+
+	sse.Send(request.RequestID, map[string]interface{}{
+		"status":  "merging",
+		"message": "Merging video and audio",
+		"percent": 100,
+	})
+
+	// The streams stay until the merge worked, a stopped job resumes from them
+	if err := ffmpeg.Merge(ctx, result.Streams, result.FilePath); err != nil {
+		os.Remove(result.FilePath)
+		if ctx.Err() != nil {
+			return ErrStopped
+		}
+		return fmt.Errorf("failed to merge streams: %w", err)
+	}
+	for _, stream := range result.Streams {
+		os.Remove(stream)
+	}
+	result.Streams = nil
+
+	fileInfo, err := os.Stat(result.FilePath)
+	if err != nil {
+		return fmt.Errorf("file not found after merge: %w", err)
+	}
+
+	jobs.Update(request.RequestID, func(r *jobs.Record) {
+		r.OutputPath = result.FilePath
+	})
+
+	result.CleanupAt = util.EstimateCleanupTime(fileInfo.Size())
+	return nil
+}
+
+// needsMP3 reports whether extractAudio has work to do
+func needsMP3(request models.DownloadVideoRequest, result *models.VideoDownloadResult) bool {
+	return request.OriginalReq.AudioOnly && !strings.EqualFold(filepath.Ext(result.FilePath), ".mp3")
+}
+
+// extractAudio converts the download of an audio-only job to MP3. yt-dlp
+// only fetches the audio, converting it here keeps ffmpeg off fetch workers.
+func extractAudio(ctx context.Context, request models.DownloadVideoRequest, result *models.VideoDownloadResult) error {
+	if !needsMP3(request, result) {
+		return nil
+	}
+	ext := filepath.Ext(result.FilePath)
+
+	sse.Send(request.RequestID, map[string]interface{}{
+		"status":  "converting",
+		"message": "Converting to MP3",
+		"percent": 100,
+	})
+
+	output := strings.TrimSuffix(result.FilePath, ext) + ".mp3"
+	if err := ffmpeg.ExtractAudio(ctx, result.FilePath, output); err != nil {
+		os.Remove(output)
+		if ctx.Err() != nil {
+			return ErrStopped
+		}
+		return fmt.Errorf("failed to convert audio: %w", err)
+	}
+	os.Remove(result.FilePath)
+
+	fileInfo, err := os.Stat(output)
+	if err != nil {
+		return fmt.Errorf("file not found after conversion: %w", err)
+	}
+
+	jobs.Update(request.RequestID, func(r *jobs.Record) {
+		r.OutputPath = output
+	})
+
+	result.FilePath = output
+	result.FileName = util.DisplayFileName(result.Title, "mp3")
+	result.CleanupAt = util.EstimateCleanupTime(fileInfo.Size())
+	return nil
+}
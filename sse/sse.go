@@ -1,23 +1,43 @@
 package sse
 
-import "sync"
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// How long a finished job's last event is replayed to reconnecting clients
+const replayWindow = 5 * time.Minute
 
 type Client struct {
 	Channel chan interface{}
 }
 
 var (
-	clients = make(map[string]*Client)
-	mu      sync.RWMutex
+	clients   = make(map[string]*Client)
+	last      = make(map[string]interface{})
+	followers = make(map[string][]string)
+	muted     = make(map[string]bool)
+	mu        sync.RWMutex
 )
 
+// Register attaches a client to id, replacing any previous connection.
+// The last event sent for id is replayed so a reconnecting client
+// immediately sees where the job is.
 func Register(id string) *Client {
 	mu.Lock()
 	defer mu.Unlock()
 
+	if previous, ok := clients[id]; ok {
+		close(previous.Channel)
+	}
+
 	client := &Client{
 		Channel: make(chan interface{}, 20),
 	}
+	if msg, ok := last[id]; ok {
+		client.Channel <- msg
+	}
 	clients[id] = client
 	return client
 }
@@ -28,21 +48,82 @@ func Get(id string) *Client {
 	return clients[id]
 }
 
-func Unregister(id string) {
+// Unregister detaches client from id, a newer connection for the same id is left alone
+func Unregister(id string, client *Client) {
 	mu.Lock()
 	defer mu.Unlock()
 
-	if client, ok := clients[id]; ok {
+	if current, ok := clients[id]; ok && current == client {
 		close(client.Channel)
 		delete(clients, id)
 	}
 }
 
 func Send(id string, data interface{}) {
-	mu.RLock()
-	client := clients[id]
-	mu.RUnlock()
+	mu.Lock()
+	defer mu.Unlock()
+
+	if !muted[id] {
+		deliver(id, data)
+	}
+	for _, follower := range followers[id] {
+		deliver(follower, data)
+	}
+}
+
+// Follow mirrors every event sent to source onto follower, used when a
+// request is attached to an identical download that is already running
+func Follow(source, follower string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	followers[source] = append(followers[source], follower)
+	if msg, ok := last[source]; ok {
+		deliver(follower, msg)
+	}
+}
 
+// Unfollow stops mirroring events of source
+func Unfollow(source string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(followers, source)
+	delete(muted, source)
+}
+
+// Mute stops delivering events of id to its own client while they are still
+// mirrored to its followers, for a stopped request whose download runs on
+func Mute(id string) {
+	mu.Lock()
+	defer mu.Unlock()
+	muted[id] = true
+}
+
+// Detach stops mirroring events of source onto one follower
+func Detach(source, follower string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	list := followers[source]
+	if i := slices.Index(list, follower); i >= 0 {
+		followers[source] = slices.Delete(list, i, i+1)
+	}
+}
+
+// Finish drops the last event of a finished job once clients had
+// replayWindow to reconnect and read it
+func Finish(id string) {
+	time.AfterFunc(replayWindow, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(last, id)
+	})
+}
+
+func deliver(id string, data interface{}) {
+	last[id] = data
+
+	client := clients[id]
 	if client != nil {
 		select {
 		case client.Channel <- data:
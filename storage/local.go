@@ -0,0 +1,187 @@
+package storage
+
+import (
+	util "backend/utils"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Local keeps files on the local disk under Root, this is also where every
+// download is written first
+type Local struct {
+	Root string
+}
+
+func NewLocal(root string) *Local {
+	return &Local{Root: root}
+}
+
+// Path maps key to its location on disk, rejecting keys that escape Root
+func (l *Local) Path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if clean == "/" || strings.Contains(key, "..") {
+		return "", fmt.Errorf("invalid key: %q", key)
+	}
+	return filepath.Join(l.Root, filepath.FromSlash(strings.TrimPrefix(clean, "/"))), nil
+}
+
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	dst, err := l.Path(key)
+	if err != nil {
+		return err
+	}
+
+	// Downloads already land in Root, nothing to copy
+	if f, ok := r.(*os.File); ok && sameFile(f.Name(), dst) {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmp := dst + ".upload"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+func (l *Local) Open(ctx context.Context, key string) (Object, error) {
+	p, err := l.Path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (l *Local) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	p, err := l.Path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	info, err := os.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ObjectInfo{}, ErrNotFound
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	return ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	p, err := l.Path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	// Drop the job directory once its last file is gone
+	os.Remove(filepath.Dir(p))
+	return nil
+}
+
+// SignedURL points to our own /downloads route
+func (l *Local) SignedURL(ctx context.Context, key, name string, expires time.Time, once bool) (string, error) {
+	return util.SignedDownloadURL(keyRequestID(key), name, expires.Unix(), once), nil
+}
+
+func (l *Local) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+
+	// Only the directory the prefix points into is walked: "<id>/" and
+	// "<id>/vi" walk <id>, a bare "<id>" walks Root
+	start := l.Root
+	if i := strings.LastIndex(prefix, "/"); i > 0 {
+		var err error
+		if start, err = l.Path(prefix[:i]); err != nil {
+			return nil, err
+		}
+	}
+
+	err := filepath.WalkDir(start, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+
+		rel, err := filepath.Rel(l.Root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if p != start && !strings.HasPrefix(key+"/", prefix) && !strings.HasPrefix(prefix, key+"/") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		objects = append(objects, ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+
+	return objects, err
+}
+
+func sameFile(a, b string) bool {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bi, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(ai, bi)
+}
+
+// keyRequestID returns the job directory of a key, the first path segment
+func keyRequestID(key string) string {
+	id, _, _ := strings.Cut(key, "/")
+	return id
+}
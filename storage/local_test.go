@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocal(t *testing.T) {
+	testBackend(t, NewLocal(t.TempDir()))
+}
+
+func TestLocalRejectsEscapingKeys(t *testing.T) {
+	root := t.TempDir()
+	l := NewLocal(filepath.Join(root, "downloads"))
+
+	for _, key := range []string{"../outside.mp4", "0123456789abcdef/../../outside.mp4", ""} {
+		if err := l.Put(context.Background(), key, bytes.NewReader([]byte("x")), 1); err == nil {
+			t.Errorf("Put(%q) succeeded", key)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "outside.mp4")); err == nil {
+		t.Fatal("a file was written outside Root")
+	}
+}
+
+func TestLocalDeleteRemovesEmptyJobDirectory(t *testing.T) {
+	l := NewLocal(t.TempDir())
+	ctx := context.Background()
+
+	if err := l.Put(ctx, "0123456789abcdef/video.mp4", bytes.NewReader([]byte("x")), 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Delete(ctx, "0123456789abcdef/video.mp4"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(l.Root, "0123456789abcdef")); !os.IsNotExist(err) {
+		t.Fatalf("job directory still exists: %v", err)
+	}
+}
+
+func TestLocalListPrefixes(t *testing.T) {
+	l := NewLocal(t.TempDir())
+	ctx := context.Background()
+
+	for _, key := range []string{"0123456789abcdef/video.mp4", "0123456789abcdef/gallery/1.jpg", "0123456789abcde0/other.mp4"} {
+		if err := l.Put(ctx, key, bytes.NewReader([]byte("x")), 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assertKeys(t, l, "0123456789abcdef/", "0123456789abcdef/gallery/1.jpg", "0123456789abcdef/video.mp4")
+	assertKeys(t, l, "0123456789abcdef/vi", "0123456789abcdef/video.mp4")
+	assertKeys(t, l, "0123456789abcdef/gallery/", "0123456789abcdef/gallery/1.jpg")
+	assertKeys(t, l, "0123456789abcde", "0123456789abcde0/other.mp4", "0123456789abcdef/gallery/1.jpg", "0123456789abcdef/video.mp4")
+	assertKeys(t, l, "fedcba9876543210/")
+
+	if _, err := l.List(ctx, "../"); err == nil {
+		t.Fatal("List of a prefix outside Root succeeded")
+	}
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// rangeReader turns ranged HTTP GETs into an Object, a request is only
+// issued on the first Read after a Seek so ServeContent's size probe is free
+type rangeReader struct {
+	ctx    context.Context
+	size   int64
+	offset int64
+	body   io.ReadCloser
+	// newRequest builds a GET for the object with byteRange as its Range
+	// header, auth included. S3 signs the Range header too.
+	newRequest func(ctx context.Context, byteRange string) (*http.Request, error)
+	client     *http.Client
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	if r.body == nil {
+		req, err := r.newRequest(r.ctx, fmt.Sprintf("bytes=%d-", r.offset))
+		if err != nil {
+			return 0, err
+		}
+
+		resp, err := r.client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+
+		switch resp.StatusCode {
+		case http.StatusPartialContent:
+		case http.StatusOK:
+			// Server ignored the Range header, skip to our offset
+			if _, err := io.CopyN(io.Discard, resp.Body, r.offset); err != nil {
+				resp.Body.Close()
+				return 0, err
+			}
+		case http.StatusNotFound:
+			resp.Body.Close()
+			return 0, ErrNotFound
+		default:
+			resp.Body.Close()
+			return 0, fmt.Errorf("ranged get failed: %s", resp.Status)
+		}
+		r.body = resp.Body
+	}
+
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *rangeReader) Seek(offset int64, whence int) (int64, error) {
+	var next int64
+	switch whence {
+	case io.SeekStart:
+		next = offset
+	case io.SeekCurrent:
+		next = r.offset + offset
+	case io.SeekEnd:
+		next = r.size + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if next < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	if next != r.offset && r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+	r.offset = next
+	return next, nil
+}
+
+func (r *rangeReader) Close() error {
+	if r.body != nil {
+		return r.body.Close()
+	}
+	return nil
+}
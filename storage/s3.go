@@ -0,0 +1,509 @@
+package storage
+
+import (
+	util "backend/utils"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	// Presigned URLs can't live longer than a week
+	maxPresignExpiry = 7 * 24 * time.Hour
+
+	// A single PUT takes 5GB at most, larger files go up in parts.
+	// An upload has up to 10000 parts of at least 5MB.
+	defaultPartSize = 64 << 20
+	maxParts        = 10000
+)
+
+// S3 stores files in an S3-compatible bucket (AWS, MinIO, R2, ...). Requests
+// are signed with AWS Signature Version 4.
+type S3 struct {
+	Endpoint  string // e.g. https://s3.eu-central-1.amazonaws.com or http://127.0.0.1:9000
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle puts the bucket in the path instead of the host name, MinIO needs this
+	PathStyle bool
+	Client    *http.Client
+	// PartSize of multipart uploads, files up to this size take a single PUT.
+	// 0 means 64MB.
+	PartSize int64
+}
+
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	partSize := s.PartSize
+	if partSize <= 0 {
+		partSize = defaultPartSize
+	}
+	if size > partSize {
+		return s.putMultipart(ctx, key, r, size, max(partSize, (size+maxParts-1)/maxParts))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key), r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+// putMultipart uploads r in parts of partSize, an upload that fails is
+// aborted so the bucket doesn't keep its parts
+func (s *S3) putMultipart(ctx context.Context, key string, r io.Reader, size, partSize int64) error {
+	uploadID, err := s.createMultipartUpload(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	type part struct {
+		PartNumber int
+		ETag       string
+	}
+	var parts []part
+
+	for number, offset := 1, int64(0); offset < size; number++ {
+		length := min(partSize, size-offset)
+
+		etag, err := s.uploadPart(ctx, key, uploadID, number, io.LimitReader(r, length), length)
+		if err != nil {
+			s.abortMultipartUpload(key, uploadID)
+			return fmt.Errorf("s3 part %d: %w", number, err)
+		}
+		parts = append(parts, part{PartNumber: number, ETag: etag})
+		offset += length
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		s.abortMultipartUpload(key, uploadID)
+		return err
+	}
+
+	query := url.Values{"uploadId": {uploadID}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.objectURL(key)+"?"+canonicalQuery(query), bytes.NewReader(body))
+	if err != nil {
+		s.abortMultipartUpload(key, uploadID)
+		return err
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		s.abortMultipartUpload(key, uploadID)
+		return err
+	}
+	defer resp.Body.Close()
+
+	// S3 may answer 200 and report the failure in the body
+	result, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode != http.StatusOK || bytes.Contains(result, []byte("<Error>")) {
+		s.abortMultipartUpload(key, uploadID)
+		return fmt.Errorf("s3 complete upload failed: %s: %s", resp.Status, strings.TrimSpace(string(result)))
+	}
+	return nil
+}
+
+func (s *S3) createMultipartUpload(ctx context.Context, key string) (string, error) {
+	query := url.Values{"uploads": {""}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.objectURL(key)+"?"+canonicalQuery(query), nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", s3Error(resp)
+	}
+
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil || result.UploadID == "" {
+		return "", fmt.Errorf("s3 create upload: no upload id: %v", err)
+	}
+	return result.UploadID, nil
+}
+
+func (s *S3) uploadPart(ctx context.Context, key, uploadID string, number int, r io.Reader, length int64) (string, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key)+"?"+canonicalQuery(query), r)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = length
+
+	resp, err := s.do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", s3Error(resp)
+	}
+	return resp.Header.Get("ETag"), nil
+}
+
+// abortMultipartUpload drops the uploaded parts, it runs after ctx may be gone
+func (s *S3) abortMultipartUpload(key, uploadID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	query := url.Values{"uploadId": {uploadID}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.objectURL(key)+"?"+canonicalQuery(query), nil)
+	if err != nil {
+		return
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		log.Printf("[STORAGE] S3 abort upload failed | Key=%s | Error=%v", key, err)
+		return
+	}
+	resp.Body.Close()
+}
+
+func (s *S3) Open(ctx context.Context, key string) (Object, error) {
+	info, err := s.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rangeReader{
+		ctx:    ctx,
+		size:   info.Size,
+		client: s.client(),
+		newRequest: func(ctx context.Context, byteRange string) (*http.Request, error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.objectURL(key), nil)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Range", byteRange)
+			s.sign(req, time.Now())
+			return req, nil
+		},
+	}, nil
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.objectURL(key), nil)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ObjectInfo{}, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ObjectInfo{}, fmt.Errorf("s3 head failed: %s", resp.Status)
+	}
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return ObjectInfo{Key: key, Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.objectURL(key), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+// SignedURL returns a presigned GET, the bucket serves Range requests itself
+func (s *S3) SignedURL(ctx context.Context, key, name string, expires time.Time, once bool) (string, error) {
+	// A bucket can't enforce single use, those go through our /downloads route
+	if once {
+		return util.SignedDownloadURL(keyRequestID(key), name, expires.Unix(), true), nil
+	}
+
+	now := time.Now().UTC()
+	ttl := expires.Sub(now)
+	if ttl <= 0 {
+		return "", fmt.Errorf("link already expired")
+	}
+	if ttl > maxPresignExpiry {
+		ttl = maxPresignExpiry
+	}
+
+	u, err := url.Parse(s.objectURL(key))
+	if err != nil {
+		return "", err
+	}
+
+	amzDate := now.Format("20060102T150405Z")
+	scope := s.scope(now)
+
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.AccessKey+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.Itoa(int(ttl.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+	query.Set("response-content-disposition", util.ContentDisposition(name))
+	u.RawQuery = canonicalQuery(query)
+
+	canonical := strings.Join([]string{
+		http.MethodGet,
+		u.EscapedPath(),
+		u.RawQuery,
+		"host:" + u.Host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+
+	signature := s.signature(now, amzDate, scope, canonical)
+	u.RawQuery += "&X-Amz-Signature=" + signature
+	return u.String(), nil
+}
+
+func (s *S3) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	token := ""
+
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.bucketURL()+"?"+canonicalQuery(query), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := s.do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			err := s3Error(resp)
+			resp.Body.Close()
+			return nil, err
+		}
+
+		var page struct {
+			Contents []struct {
+				Key          string
+				Size         int64
+				LastModified time.Time
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("s3 list decode failed: %w", err)
+		}
+
+		for _, c := range page.Contents {
+			objects = append(objects, ObjectInfo{Key: c.Key, Size: c.Size, ModTime: c.LastModified})
+		}
+
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+func (s *S3) bucketURL() string {
+	endpoint := strings.TrimSuffix(s.Endpoint, "/")
+	if s.PathStyle {
+		return endpoint + "/" + s.Bucket
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return endpoint + "/" + s.Bucket
+	}
+	u.Host = s.Bucket + "." + u.Host
+	return u.String()
+}
+
+func (s *S3) objectURL(key string) string {
+	return s.bucketURL() + "/" + escapePath(key)
+}
+
+func (s *S3) client() *http.Client {
+	if s.Client != nil {
+		return s.Client
+	}
+	return http.DefaultClient
+}
+
+func (s *S3) do(req *http.Request) (*http.Response, error) {
+	s.sign(req, time.Now())
+	return s.client().Do(req)
+}
+
+// sign adds SigV4 headers, the body is sent as UNSIGNED-PAYLOAD so large files aren't hashed twice
+func (s *S3) sign(req *http.Request, t time.Time) {
+	t = t.UTC()
+	amzDate := t.Format("20060102T150405Z")
+	scope := s.scope(t)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": unsignedPayload,
+		"x-amz-date":           amzDate,
+	}
+	if r := req.Header.Get("Range"); r != "" {
+		headers["range"] = r
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		unsignedPayload,
+	}, "\n")
+
+	signature := s.signature(t, amzDate, scope, canonical)
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature,
+	))
+}
+
+func (s *S3) scope(t time.Time) string {
+	return t.Format("20060102") + "/" + s.Region + "/s3/aws4_request"
+}
+
+func (s *S3) signature(t time.Time, amzDate, scope, canonicalRequest string) string {
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), t.Format("20060102"))
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery sorts and encodes the query as SigV4 expects, spaces as %20 not +
+func canonicalQuery(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		vs := append([]string(nil), values[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func escapePath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = awsEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// awsEscape percent-encodes everything but the RFC 3986 unreserved characters
+func awsEscape(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3Error(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	return fmt.Errorf("s3 %s failed: %s: %s", resp.Request.Method, resp.Status, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,368 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testAccessKey = "AKIDEXAMPLE"
+	testSecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	testRegion    = "eu-central-1"
+	testBucket    = "prodl"
+)
+
+type fakeObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+// fakeS3 is an in-memory bucket that rejects every request whose SigV4
+// signature doesn't match, it serves path-style URLs
+type fakeS3 struct {
+	t *testing.T
+
+	mu       sync.Mutex
+	objects  map[string]fakeObject
+	uploads  map[string]map[int][]byte
+	nextID   int
+	failPart int  // answers 500 to this part number
+	forged   bool // a bad signature is expected, not a test failure
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *S3) {
+	f := &fakeS3{t: t, objects: map[string]fakeObject{}, uploads: map[string]map[int][]byte{}}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+
+	return f, &S3{
+		Endpoint:  server.URL,
+		Region:    testRegion,
+		Bucket:    testBucket,
+		AccessKey: testAccessKey,
+		SecretKey: testSecretKey,
+		PathStyle: true,
+		Client:    server.Client(),
+	}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := verifySignature(r); err != nil {
+		if !f.forged {
+			f.t.Errorf("%s %s: %v", r.Method, r.URL, err)
+		}
+		http.Error(w, "<Error><Code>SignatureDoesNotMatch</Code></Error>", http.StatusForbidden)
+		return
+	}
+
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+testBucket)
+	if !ok {
+		http.Error(w, "<Error><Code>NoSuchBucket</Code></Error>", http.StatusNotFound)
+		return
+	}
+	key = strings.TrimPrefix(key, "/")
+	query := r.URL.Query()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		f.list(w, query)
+
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.nextID++
+		id := fmt.Sprintf("upload-%d", f.nextID)
+		f.uploads[id] = map[int][]byte{}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>", key, id)
+
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		parts, ok := f.uploads[query.Get("uploadId")]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchUpload</Code></Error>", http.StatusNotFound)
+			return
+		}
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		if number == f.failPart {
+			http.Error(w, "<Error><Code>InternalError</Code></Error>", http.StatusInternalServerError)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		parts[number] = data
+		w.Header().Set("ETag", fmt.Sprintf(`"etag-%d"`, number))
+
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		f.complete(w, r, key, query.Get("uploadId"))
+
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		f.objects[key] = fakeObject{data: data, modTime: time.Now()}
+
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		object, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		http.ServeContent(w, r, key, object.modTime, bytes.NewReader(object.data))
+
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		f.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+	}
+}
+
+// list answers ListObjectsV2 two keys per page, so continuation is exercised
+func (f *fakeS3) list(w http.ResponseWriter, query url.Values) {
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, query.Get("prefix")) && key > query.Get("continuation-token") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	type content struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	page := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Contents              []content
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+	}{}
+
+	for i, key := range keys {
+		if i == 2 {
+			page.IsTruncated = true
+			page.NextContinuationToken = keys[1]
+			break
+		}
+		object := f.objects[key]
+		page.Contents = append(page.Contents, content{Key: key, Size: int64(len(object.data)), LastModified: object.modTime})
+	}
+
+	xml.NewEncoder(w).Encode(page)
+}
+
+func (f *fakeS3) complete(w http.ResponseWriter, r *http.Request, key, uploadID string) {
+	parts, ok := f.uploads[uploadID]
+	if !ok {
+		http.Error(w, "<Error><Code>NoSuchUpload</Code></Error>", http.StatusNotFound)
+		return
+	}
+
+	var request struct {
+		Parts []struct {
+			PartNumber int
+			ETag       string
+		} `xml:"Part"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "<Error><Code>MalformedXML</Code></Error>", http.StatusBadRequest)
+		return
+	}
+
+	var data []byte
+	for i, part := range request.Parts {
+		if part.PartNumber != i+1 || part.ETag != fmt.Sprintf(`"etag-%d"`, part.PartNumber) {
+			fmt.Fprint(w, "<Error><Code>InvalidPart</Code></Error>")
+			return
+		}
+		data = append(data, parts[part.PartNumber]...)
+	}
+
+	delete(f.uploads, uploadID)
+	f.objects[key] = fakeObject{data: data, modTime: time.Now()}
+	fmt.Fprintf(w, "<CompleteMultipartUploadResult><Key>%s</Key></CompleteMultipartUploadResult>", key)
+}
+
+// verifySignature checks the Authorization header the way S3 does, from
+// the request as it arrived
+func verifySignature(r *http.Request) error {
+	auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+	if !ok {
+		return fmt.Errorf("no SigV4 authorization: %q", r.Header.Get("Authorization"))
+	}
+
+	fields := map[string]string{}
+	for _, field := range strings.Split(auth, ", ") {
+		name, value, _ := strings.Cut(field, "=")
+		fields[name] = value
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	date, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil || time.Since(date).Abs() > 15*time.Minute {
+		return fmt.Errorf("bad X-Amz-Date %q", amzDate)
+	}
+
+	scope := date.Format("20060102") + "/" + testRegion + "/s3/aws4_request"
+	if fields["Credential"] != testAccessKey+"/"+scope {
+		return fmt.Errorf("credential = %q", fields["Credential"])
+	}
+
+	signed := strings.Split(fields["SignedHeaders"], ";")
+	for _, required := range []string{"host", "x-amz-content-sha256", "x-amz-date"} {
+		if !contains(signed, required) {
+			return fmt.Errorf("%s is not signed", required)
+		}
+	}
+	if r.Header.Get("Range") != "" && !contains(signed, "range") {
+		return fmt.Errorf("range is not signed")
+	}
+
+	var headers strings.Builder
+	for _, name := range signed {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	query := r.URL.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var pairs []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			pairs = append(pairs, queryEscape(name)+"="+queryEscape(value))
+		}
+	}
+
+	canonical := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		strings.Join(pairs, "&"),
+		headers.String(),
+		fields["SignedHeaders"],
+		r.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	hash := sha256.Sum256([]byte(canonical))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := []byte("AWS4" + testSecretKey)
+	for _, part := range []string{date.Format("20060102"), testRegion, "s3", "aws4_request"} {
+		key = sum(key, part)
+	}
+
+	if want := hex.EncodeToString(sum(key, stringToSign)); fields["Signature"] != want {
+		return fmt.Errorf("signature = %s, want %s for\n%s", fields["Signature"], want, canonical)
+	}
+	return nil
+}
+
+func sum(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func queryEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestS3(t *testing.T) {
+	_, s := newFakeS3(t)
+	testBackend(t, s)
+}
+
+func TestS3RejectsWrongSecret(t *testing.T) {
+	f, s := newFakeS3(t)
+	s.SecretKey = "wrong"
+	f.forged = true
+
+	err := s.Put(context.Background(), "0123456789abcdef/video.mp4", strings.NewReader("x"), 1)
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("Put with a wrong secret: err = %v, want a 403", err)
+	}
+}
+
+func TestS3MultipartUpload(t *testing.T) {
+	f, s := newFakeS3(t)
+	s.PartSize = 300
+
+	content := []byte(strings.Repeat("0123456789", 100))
+	if err := s.Put(context.Background(), "0123456789abcdef/video.mp4", bytes.NewReader(content), int64(len(content))); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	if got := f.objects["0123456789abcdef/video.mp4"].data; !bytes.Equal(got, content) {
+		t.Fatalf("stored %d bytes, want the %d uploaded", len(got), len(content))
+	}
+	if f.nextID != 1 {
+		t.Fatalf("%d multipart uploads, want 1", f.nextID)
+	}
+	if len(f.uploads) != 0 {
+		t.Fatalf("%d uploads left open", len(f.uploads))
+	}
+}
+
+func TestS3MultipartUploadAbortsOnFailure(t *testing.T) {
+	f, s := newFakeS3(t)
+	s.PartSize = 300
+	f.failPart = 2
+
+	content := []byte(strings.Repeat("0123456789", 100))
+	if err := s.Put(context.Background(), "0123456789abcdef/video.mp4", bytes.NewReader(content), int64(len(content))); err == nil {
+		t.Fatal("Put succeeded although a part failed")
+	}
+
+	if _, ok := f.objects["0123456789abcdef/video.mp4"]; ok {
+		t.Fatal("object exists after a failed upload")
+	}
+	if len(f.uploads) != 0 {
+		t.Fatalf("failed upload wasn't aborted, %d open", len(f.uploads))
+	}
+}
+
+func TestS3SmallFileSkipsMultipart(t *testing.T) {
+	f, s := newFakeS3(t)
+	s.PartSize = 300
+
+	if err := s.Put(context.Background(), "0123456789abcdef/video.mp4", strings.NewReader("small"), 5); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if f.nextID != 0 {
+		t.Fatal("a file below the part size used a multipart upload")
+	}
+}
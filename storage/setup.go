@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"backend/config"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Setup selects the backend configured by STORAGE_BACKEND
+func Setup(cfg *config.Config) error {
+	switch cfg.StorageBackend {
+	case "", "local":
+		current = NewLocal("downloads")
+
+	case "s3":
+		if cfg.S3Bucket == "" {
+			return fmt.Errorf("S3_BUCKET is required for the s3 storage backend")
+		}
+		current = &S3{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+			PathStyle: cfg.S3PathStyle,
+			Client:    httpClient(),
+		}
+
+	case "webdav":
+		if cfg.WebDAVURL == "" {
+			return fmt.Errorf("WEBDAV_URL is required for the webdav storage backend")
+		}
+		current = &WebDAV{
+			BaseURL:  cfg.WebDAVURL,
+			Username: cfg.WebDAVUser,
+			Password: cfg.WebDAVPassword,
+			Client:   httpClient(),
+		}
+
+	case "sftp":
+		if cfg.SFTPAddr == "" {
+			return fmt.Errorf("SFTP_ADDR is required for the sftp storage backend")
+		}
+		backend := &SFTP{
+			Addr:           cfg.SFTPAddr,
+			User:           cfg.SFTPUser,
+			Password:       cfg.SFTPPassword,
+			KeyFile:        cfg.SFTPKeyFile,
+			KnownHostsFile: cfg.SFTPKnownHosts,
+			HostKey:        cfg.SFTPHostKey,
+			Root:           cfg.SFTPRoot,
+		}
+		if _, err := backend.hostKeyCallback(); err != nil {
+			return err
+		}
+		current = backend
+
+	default:
+		return fmt.Errorf("unknown storage backend %q", cfg.StorageBackend)
+	}
+
+	log.Printf("[STORAGE] Using %s backend", cfg.StorageBackend)
+	return nil
+}
+
+// No overall timeout, serving a large file to a slow client can take hours
+func httpClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			ResponseHeaderTimeout: time.Minute,
+		},
+	}
+}
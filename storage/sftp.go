@@ -0,0 +1,266 @@
+package storage
+
+import (
+	util "backend/utils"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTP stores files on a remote host over SSH
+type SFTP struct {
+	Addr     string // host:port
+	User     string
+	Password string
+	KeyFile  string // private key, used instead of Password when set
+	// KnownHostsFile or HostKey (authorized_keys format) pins the server
+	// key, one of them is required
+	KnownHostsFile string
+	HostKey        string
+	Root           string // remote directory files are stored under
+
+	mu     sync.Mutex
+	client *sftp.Client
+}
+
+// session returns the shared SFTP client, dialing it when needed
+func (s *SFTP) session() (*sftp.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client != nil {
+		return s.client, nil
+	}
+
+	config, err := s.sshConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := ssh.Dial("tcp", s.Addr, config)
+	if err != nil {
+		return nil, fmt.Errorf("sftp dial %s: %w", s.Addr, err)
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("sftp subsystem: %w", err)
+	}
+
+	// A dropped connection is dialed again on the next call
+	go func() {
+		client.Wait()
+		conn.Close()
+
+		s.mu.Lock()
+		if s.client == client {
+			s.client = nil
+		}
+		s.mu.Unlock()
+	}()
+
+	s.client = client
+	return client, nil
+}
+
+func (s *SFTP) sshConfig() (*ssh.ClientConfig, error) {
+	var auth []ssh.AuthMethod
+
+	if s.KeyFile != "" {
+		key, err := os.ReadFile(s.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sftp key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse sftp key: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if s.Password != "" {
+		auth = append(auth, ssh.Password(s.Password))
+	}
+
+	hostKey, err := s.hostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ssh.ClientConfig{
+		User:            s.User,
+		Auth:            auth,
+		HostKeyCallback: hostKey,
+		Timeout:         15 * time.Second,
+	}, nil
+}
+
+// hostKeyCallback verifies the server against KnownHostsFile or HostKey,
+// an unverified server could read every upload
+func (s *SFTP) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	switch {
+	case s.KnownHostsFile != "":
+		callback, err := knownhosts.New(s.KnownHostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load known hosts: %w", err)
+		}
+		return callback, nil
+
+	case s.HostKey != "":
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(s.HostKey))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse sftp host key: %w", err)
+		}
+		return ssh.FixedHostKey(key), nil
+
+	default:
+		return nil, errors.New("SFTP_KNOWN_HOSTS or SFTP_HOST_KEY is required to verify the sftp server")
+	}
+}
+
+func (s *SFTP) remotePath(key string) string {
+	return path.Join(s.Root, path.Clean("/"+key))
+}
+
+func (s *SFTP) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	client, err := s.session()
+	if err != nil {
+		return err
+	}
+
+	dst := s.remotePath(key)
+	if err := client.MkdirAll(path.Dir(dst)); err != nil {
+		return fmt.Errorf("sftp mkdir %s: %w", path.Dir(dst), err)
+	}
+
+	tmp := dst + ".upload"
+	f, err := client.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.ReadFrom(&contextReader{ctx: ctx, r: r}); err != nil {
+		f.Close()
+		client.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		client.Remove(tmp)
+		return err
+	}
+
+	// Plain SFTP v3 rename fails when the target exists
+	if err := client.PosixRename(tmp, dst); err == nil {
+		return nil
+	}
+	client.Remove(dst)
+	return client.Rename(tmp, dst)
+}
+
+func (s *SFTP) Open(ctx context.Context, key string) (Object, error) {
+	client, err := s.session()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := client.Open(s.remotePath(key))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return f, nil
+}
+
+func (s *SFTP) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	client, err := s.session()
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	info, err := client.Stat(s.remotePath(key))
+	if err != nil {
+		return ObjectInfo{}, notFound(err)
+	}
+	return ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s *SFTP) Delete(ctx context.Context, key string) error {
+	client, err := s.session()
+	if err != nil {
+		return err
+	}
+	return notFound(client.Remove(s.remotePath(key)))
+}
+
+// SignedURL points to our /downloads route, the SSH credentials never reach the client
+func (s *SFTP) SignedURL(ctx context.Context, key, name string, expires time.Time, once bool) (string, error) {
+	return util.SignedDownloadURL(keyRequestID(key), name, expires.Unix(), once), nil
+}
+
+func (s *SFTP) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	client, err := s.session()
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []ObjectInfo
+	root := s.remotePath("")
+
+	walker := client.Walk(root)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+
+		key := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), root), "/")
+		if key == "" {
+			continue
+		}
+		if walker.Stat().IsDir() {
+			if !strings.HasPrefix(key, prefix) && !strings.HasPrefix(prefix, key+"/") {
+				walker.SkipDir()
+			}
+			continue
+		}
+		if strings.HasPrefix(key, prefix) {
+			info := walker.Stat()
+			objects = append(objects, ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		}
+	}
+
+	return objects, nil
+}
+
+// notFound maps a missing remote file to ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// contextReader stops a copy once ctx is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"strings"
+	"testing"
+
+	"backend/config"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// serveSFTP runs an SSH server with the sftp subsystem on a random port,
+// it accepts user "prodl" with password "secret"
+func serveSFTP(t *testing.T) (addr string, hostKey ssh.PublicKey) {
+	t.Helper()
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == "prodl" && string(password) == "secret" {
+				return nil, nil
+			}
+			return nil, ssh.ErrNoAuth
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSHConn(conn, config)
+		}
+	}()
+
+	return listener.Addr().String(), signer.PublicKey()
+}
+
+func serveSSHConn(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "session only")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+
+		go func() {
+			for req := range channelRequests {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+
+				server, err := sftp.NewServer(channel)
+				if err != nil {
+					channel.Close()
+					return
+				}
+				server.Serve()
+				server.Close()
+				return
+			}
+		}()
+	}
+}
+
+func TestSFTP(t *testing.T) {
+	addr, hostKey := serveSFTP(t)
+
+	testBackend(t, &SFTP{
+		Addr:     addr,
+		User:     "prodl",
+		Password: "secret",
+		HostKey:  string(ssh.MarshalAuthorizedKey(hostKey)),
+		Root:     t.TempDir(),
+	})
+}
+
+func TestSFTPRejectsOtherHostKey(t *testing.T) {
+	addr, _ := serveSFTP(t)
+
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &SFTP{
+		Addr:     addr,
+		User:     "prodl",
+		Password: "secret",
+		HostKey:  string(ssh.MarshalAuthorizedKey(other)),
+		Root:     t.TempDir(),
+	}
+	if _, err := s.Stat(context.Background(), "0123456789abcdef/video.mp4"); err == nil || !strings.Contains(err.Error(), "host key") {
+		t.Fatalf("Stat against a server with another key: err = %v, want a host key mismatch", err)
+	}
+}
+
+func TestSetupSFTPRequiresHostKey(t *testing.T) {
+	previous := current
+	t.Cleanup(func() { current = previous })
+
+	err := Setup(&config.Config{StorageBackend: "sftp", SFTPAddr: "127.0.0.1:22", SFTPUser: "prodl"})
+	if err == nil {
+		t.Fatal("Setup accepted an sftp backend without a known hosts file or host key")
+	}
+
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Setup(&config.Config{
+		StorageBackend: "sftp",
+		SFTPAddr:       "127.0.0.1:22",
+		SFTPUser:       "prodl",
+		SFTPHostKey:    string(ssh.MarshalAuthorizedKey(key)),
+	})
+	if err != nil {
+		t.Fatalf("Setup with a pinned host key: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+// ObjectInfo describes a stored file, Key is relative to the backend root
+// and always uses forward slashes, e.g. "<requestID>/<file>.mp4"
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Object is an open stored file, seekable so it can serve Range requests
+type Object interface {
+	io.ReadSeekCloser
+}
+
+// Storage is where finished downloads are kept
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	Open(ctx context.Context, key string) (Object, error)
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+	// SignedURL returns a link the client can download key from until
+	// expires, name is the file name the client should save it as
+	SignedURL(ctx context.Context, key, name string, expires time.Time, once bool) (string, error)
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+}
+
+var current Storage = NewLocal("downloads")
+
+// Default returns the configured backend, local disk unless Setup chose another one
+func Default() Storage {
+	return current
+}
+
+// DeletePrefix removes every object under prefix, e.g. all files of a job
+func DeletePrefix(ctx context.Context, s Storage, prefix string) error {
+	objects, err := s.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+
+	for _, object := range objects {
+		if err := s.Delete(ctx, object.Key); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testBackend runs Put, Open with a range, Stat, List and Delete against s
+func testBackend(t *testing.T, s Storage) {
+	t.Helper()
+	ctx := context.Background()
+
+	video := []byte(strings.Repeat("0123456789", 100))
+	files := map[string][]byte{
+		"0123456789abcdef/My Video.mp4": video,
+		"0123456789abcdef/audio.m4a":    []byte("audio"),
+		"fedcba9876543210/other.mp4":    []byte("other"),
+	}
+	for key, content := range files {
+		if err := s.Put(ctx, key, bytes.NewReader(content), int64(len(content))); err != nil {
+			t.Fatalf("Put(%q): %v", key, err)
+		}
+	}
+
+	info, err := s.Stat(ctx, "0123456789abcdef/My Video.mp4")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Size != int64(len(video)) {
+		t.Fatalf("Stat size = %d, want %d", info.Size, len(video))
+	}
+	if _, err := s.Stat(ctx, "0123456789abcdef/missing.mp4"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Stat of a missing key: err = %v, want ErrNotFound", err)
+	}
+
+	object, err := s.Open(ctx, "0123456789abcdef/My Video.mp4")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, err := object.Seek(100, io.SeekStart); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	part := make([]byte, 50)
+	if _, err := io.ReadFull(object, part); err != nil {
+		t.Fatalf("read after Seek: %v", err)
+	}
+	if !bytes.Equal(part, video[100:150]) {
+		t.Fatalf("range = %q, want %q", part, video[100:150])
+	}
+	if end, err := object.Seek(0, io.SeekEnd); err != nil || end != int64(len(video)) {
+		t.Fatalf("Seek to end = %d, %v, want %d", end, err, len(video))
+	}
+	if _, err := object.Seek(990, io.SeekStart); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	rest, err := io.ReadAll(object)
+	if err != nil || !bytes.Equal(rest, video[990:]) {
+		t.Fatalf("tail = %q, %v, want %q", rest, err, video[990:])
+	}
+	object.Close()
+
+	if _, err := s.Open(ctx, "0123456789abcdef/missing.mp4"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Open of a missing key: err = %v, want ErrNotFound", err)
+	}
+
+	assertKeys(t, s, "0123456789abcdef/", "0123456789abcdef/My Video.mp4", "0123456789abcdef/audio.m4a")
+	assertKeys(t, s, "", "0123456789abcdef/My Video.mp4", "0123456789abcdef/audio.m4a", "fedcba9876543210/other.mp4")
+
+	if err := s.Delete(ctx, "0123456789abcdef/My Video.mp4"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Stat(ctx, "0123456789abcdef/My Video.mp4"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Stat after Delete: err = %v, want ErrNotFound", err)
+	}
+	assertKeys(t, s, "0123456789abcdef/", "0123456789abcdef/audio.m4a")
+
+	if err := DeletePrefix(ctx, s, "0123456789abcdef/"); err != nil {
+		t.Fatalf("DeletePrefix: %v", err)
+	}
+	assertKeys(t, s, "", "fedcba9876543210/other.mp4")
+}
+
+func assertKeys(t *testing.T, s Storage, prefix string, want ...string) {
+	t.Helper()
+
+	objects, err := s.List(context.Background(), prefix)
+	if err != nil {
+		t.Fatalf("List(%q): %v", prefix, err)
+	}
+
+	var keys []string
+	for _, o := range objects {
+		keys = append(keys, o.Key)
+	}
+	sort.Strings(keys)
+
+	if strings.Join(keys, ",") != strings.Join(want, ",") {
+		t.Fatalf("List(%q) = %q, want %q", prefix, keys, want)
+	}
+}
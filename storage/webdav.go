@@ -0,0 +1,289 @@
+package storage
+
+import (
+	util "backend/utils"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// WebDAV stores files on a WebDAV share (Nextcloud, Apache mod_dav, rclone serve webdav, ...)
+type WebDAV struct {
+	BaseURL  string // collection files are stored under, e.g. https://dav.example.com/remote.php/dav/files/prodl
+	Username string
+	Password string
+	Client   *http.Client
+}
+
+func (w *WebDAV) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if err := w.mkdirs(ctx, path.Dir(key)); err != nil {
+		return err
+	}
+
+	req, err := w.request(ctx, http.MethodPut, key, r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+
+	resp, err := w.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("webdav put failed: %s", resp.Status)
+	}
+	return nil
+}
+
+func (w *WebDAV) Open(ctx context.Context, key string) (Object, error) {
+	info, err := w.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rangeReader{
+		ctx:    ctx,
+		size:   info.Size,
+		client: w.client(),
+		newRequest: func(ctx context.Context, byteRange string) (*http.Request, error) {
+			req, err := w.request(ctx, http.MethodGet, key, nil)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Range", byteRange)
+			return req, nil
+		},
+	}, nil
+}
+
+func (w *WebDAV) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	req, err := w.request(ctx, http.MethodHead, key, nil)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	resp, err := w.client().Do(req)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ObjectInfo{}, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ObjectInfo{}, fmt.Errorf("webdav head failed: %s", resp.Status)
+	}
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return ObjectInfo{Key: key, Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+func (w *WebDAV) Delete(ctx context.Context, key string) error {
+	req, err := w.request(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := w.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return nil
+	case http.StatusNotFound:
+		return ErrNotFound
+	default:
+		return fmt.Errorf("webdav delete failed: %s", resp.Status)
+	}
+}
+
+// SignedURL points to our /downloads route, the share credentials never reach the client
+func (w *WebDAV) SignedURL(ctx context.Context, key, name string, expires time.Time, once bool) (string, error) {
+	return util.SignedDownloadURL(keyRequestID(key), name, expires.Unix(), once), nil
+}
+
+// List walks the collections below prefix with PROPFIND Depth: 1
+func (w *WebDAV) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	dir := prefix
+	if !strings.HasSuffix(dir, "/") {
+		dir = path.Dir(dir)
+		if dir == "." {
+			dir = ""
+		}
+	}
+
+	var objects []ObjectInfo
+	pending := []string{strings.TrimSuffix(dir, "/")}
+
+	for len(pending) > 0 {
+		current := pending[0]
+		pending = pending[1:]
+
+		entries, err := w.propfind(ctx, current)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range entries {
+			if !strings.HasPrefix(e.Key, prefix) && !strings.HasPrefix(prefix, e.Key+"/") {
+				continue
+			}
+			if e.dir {
+				pending = append(pending, e.Key)
+				continue
+			}
+			objects = append(objects, e.ObjectInfo)
+		}
+	}
+
+	return objects, nil
+}
+
+type davEntry struct {
+	ObjectInfo
+	dir bool
+}
+
+func (w *WebDAV) propfind(ctx context.Context, dir string) ([]davEntry, error) {
+	body := strings.NewReader(`<?xml version="1.0"?><d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getcontentlength/><d:getlastmodified/></d:prop></d:propfind>`)
+
+	req, err := w.request(ctx, "PROPFIND", dir+"/", body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Depth", "1")
+	req.Header.Set("Content-Type", "application/xml")
+
+	resp, err := w.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("webdav propfind failed: %s", resp.Status)
+	}
+
+	var multistatus struct {
+		Responses []struct {
+			Href string `xml:"href"`
+			Prop struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+				ContentLength int64  `xml:"getcontentlength"`
+				LastModified  string `xml:"getlastmodified"`
+			} `xml:"propstat>prop"`
+		} `xml:"response"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&multistatus); err != nil {
+		return nil, fmt.Errorf("webdav propfind decode failed: %w", err)
+	}
+
+	base, err := url.Parse(strings.TrimSuffix(w.BaseURL, "/") + "/")
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []davEntry
+	for _, r := range multistatus.Responses {
+		href, err := url.Parse(r.Href)
+		if err != nil {
+			continue
+		}
+
+		rel := strings.TrimPrefix(base.ResolveReference(href).Path, base.Path)
+		rel = strings.Trim(rel, "/")
+		if rel == strings.Trim(dir, "/") {
+			continue // the collection itself
+		}
+
+		modTime, _ := http.ParseTime(r.Prop.LastModified)
+		entries = append(entries, davEntry{
+			ObjectInfo: ObjectInfo{Key: rel, Size: r.Prop.ContentLength, ModTime: modTime},
+			dir:        r.Prop.ResourceType.Collection != nil,
+		})
+	}
+	return entries, nil
+}
+
+// mkdirs creates every collection of dir, MKCOL can't create parents
+func (w *WebDAV) mkdirs(ctx context.Context, dir string) error {
+	if dir == "." || dir == "" {
+		return nil
+	}
+
+	current := ""
+	for _, segment := range strings.Split(dir, "/") {
+		current = path.Join(current, segment)
+
+		req, err := w.request(ctx, "MKCOL", current+"/", nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := w.client().Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		// 405 means it already exists
+		if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusMethodNotAllowed {
+			return fmt.Errorf("webdav mkcol %s failed: %s", current, resp.Status)
+		}
+	}
+	return nil
+}
+
+func (w *WebDAV) request(ctx context.Context, method, key string, body io.Reader) (*http.Request, error) {
+	target := strings.TrimSuffix(w.BaseURL, "/") + "/" + escapeDAVPath(key)
+
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if w.Username != "" {
+		req.SetBasicAuth(w.Username, w.Password)
+	}
+	return req, nil
+}
+
+func (w *WebDAV) client() *http.Client {
+	if w.Client != nil {
+		return w.Client
+	}
+	return http.DefaultClient
+}
+
+func escapeDAVPath(key string) string {
+	trailing := strings.HasSuffix(key, "/")
+	segments := strings.Split(strings.Trim(key, "/"), "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	escaped := strings.Join(segments, "/")
+	if trailing && escaped != "" {
+		escaped += "/"
+	}
+	return escaped
+}
@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+func TestWebDAV(t *testing.T) {
+	dav := &webdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS(),
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "prodl" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		dav.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	testBackend(t, &WebDAV{
+		BaseURL:  server.URL + "/dav",
+		Username: "prodl",
+		Password: "secret",
+		Client:   server.Client(),
+	})
+}
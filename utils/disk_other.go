@@ -0,0 +1,9 @@
+//go:build !unix
+
+package util
+
+import "errors"
+
+func DiskFree(dir string) (int64, error) {
+	return 0, errors.New("disk free space not supported on this platform")
+}
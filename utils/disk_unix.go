@@ -0,0 +1,14 @@
+//go:build unix
+
+package util
+
+import "syscall"
+
+// DiskFree returns the bytes available to unprivileged users on the filesystem holding dir
+func DiskFree(dir string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
@@ -2,8 +2,10 @@ package util
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"log"
 	"net/url"
 	"os"
@@ -11,6 +13,7 @@ import (
 	"regexp"
 	"strings"
 	"time"
+	"unicode"
 )
 
 // check if the downlaod directory exist
@@ -30,6 +33,28 @@ func EnsureRootDirectory() error {
 	return nil
 }
 
+// EnsureJobDirectory creates downloads/<requestID>, every job writes into its own directory
+func EnsureJobDirectory(requestID string) (string, error) {
+	if !IsRequestID(requestID) {
+		return "", fmt.Errorf("invalid request id: %q", requestID)
+	}
+
+	dir := filepath.Join("downloads", requestID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory '%s': %w", dir, err)
+	}
+	return dir, nil
+}
+
+// IsRequestID checks that id looks like GenerateRequestID output, safe to use in paths
+func IsRequestID(id string) bool {
+	if len(id) != 16 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
 // GenerateRequestID creates a short 16-char hex ID for websocket
 func GenerateRequestID() string {
 	bytes := make([]byte, 8)
@@ -51,13 +76,19 @@ func EstimateCleanupTime(size int64) int64 {
 // FindDownloadedFile locates downloaded file by requestID and optional tag
 func FindDownloadedFile(dir, requestID, tag string) (string, error) {
 	matches, _ := filepath.Glob(fmt.Sprintf("%s/%s*%s*", dir, requestID, tag))
-	if len(matches) == 0 {
-		return "", fmt.Errorf("file not found: %s", tag)
+	for _, match := range matches {
+		// Leftovers of an unfinished download
+		switch filepath.Ext(match) {
+		case ".part", ".ytdl", ".temp":
+			continue
+		}
+		return match, nil
 	}
-	return matches[0], nil
+	return "", fmt.Errorf("file not found: %s", tag)
 }
 
-func DeleteFilesOlderThan(dir string, olderThan time.Duration) error {
+// DeleteFilesOlderThan removes old files from dir, files for which keep returns true are left alone
+func DeleteFilesOlderThan(dir string, olderThan time.Duration, keep func(path string) bool) error {
 	files, err := os.ReadDir(dir)
 	if err != nil {
 		return err
@@ -73,7 +104,11 @@ func DeleteFilesOlderThan(dir string, olderThan time.Duration) error {
 
 		if now.Sub(info.ModTime()) > olderThan {
 			path := filepath.Join(dir, file.Name())
-			if err := os.Remove(path); err != nil {
+			if keep != nil && keep(path) {
+				continue
+			}
+			// Job directories are removed together with their files
+			if err := os.RemoveAll(path); err != nil {
 				log.Printf("[CLEANUP] Failed to delete %s: %v", path, err)
 			} else {
 				log.Printf("[CLEANUP] Deleted old file: %s", path)
@@ -112,23 +147,22 @@ func SanitizeURL(rawURL string) string {
 	return sanitized
 }
 
-var downloadLimit = make(chan struct{}, 25)
+// Live recordings run for a long time, keep them out of the fetch workers
+var liveLimit = make(chan struct{}, 5)
 
-// Block until slot is acquired
-func AcquireSlot() {
-	downloadLimit <- struct{}{}
+func AcquireLiveSlot() {
+	liveLimit <- struct{}{}
 }
 
-func ReleaseSlot() {
+func ReleaseLiveSlot() {
 	select {
-	case <-downloadLimit:
+	case <-liveLimit:
 	default:
 	}
 }
 
-// Helper: check if all slots are full (non-blocking)
-func SlotsFull() bool {
-	return len(downloadLimit) == cap(downloadLimit)
+func LiveSlotsFull() bool {
+	return len(liveLimit) == cap(liveLimit)
 }
 
 func SanitizedFileName(name string) string {
@@ -165,3 +199,89 @@ func SanitizedFileName(name string) string {
 
 	return name
 }
+
+// DisplayFileName builds the user facing file name, keeping the title in its
+// original script and only dropping characters no filesystem accepts
+func DisplayFileName(title, ext string) string {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return -1
+		}
+		return r
+	}, title)
+
+	name = strings.Join(strings.Fields(name), " ")
+	name = strings.Trim(name, ". ")
+
+	// Most filesystems cap names at 255 bytes, keep room for the extension
+	const maxBytes = 200
+	if len(name) > maxBytes {
+		cut := 0
+		for i := range name {
+			if i > maxBytes {
+				break
+			}
+			cut = i
+		}
+		name = strings.TrimSpace(name[:cut])
+	}
+
+	if name == "" {
+		name = "video"
+	}
+
+	return name + "." + ext
+}
+
+// ContentDisposition returns an RFC 6266 attachment header with an ASCII
+// fallback and the UTF-8 name encoded as described in RFC 5987
+func ContentDisposition(name string) string {
+	ext := filepath.Ext(name)
+	fallback := SanitizedFileName(strings.TrimSuffix(name, ext))
+	if ext != "" {
+		fallback += "." + SanitizedFileName(ext)
+	}
+
+	return fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`, fallback, encodeRFC5987(name))
+}
+
+func encodeRFC5987(value string) string {
+	var b strings.Builder
+	for _, c := range []byte(value) {
+		if isAttrChar(c) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// attr-char from RFC 5987 section 3.2.1
+func isAttrChar(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", c) >= 0
+}
+
+// DownloadURL is the public path of a finished file
+func DownloadURL(requestID, name string) string {
+	return "/downloads/" + requestID + "/" + url.PathEscape(name)
+}
+
+// FileSHA256 returns the hex SHA-256 of a file
+func FileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,38 @@
+package util
+
+import (
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// Go's builtin table misses most media types and /etc/mime.types is not
+// always installed in our containers
+var mediaTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".webm": "video/webm",
+	".mkv":  "video/x-matroska",
+	".mov":  "video/quicktime",
+	".ts":   "video/mp2t",
+	".flv":  "video/x-flv",
+	".mp3":  "audio/mpeg",
+	".m4a":  "audio/mp4",
+	".aac":  "audio/aac",
+	".opus": "audio/ogg",
+	".ogg":  "audio/ogg",
+	".wav":  "audio/wav",
+	".flac": "audio/flac",
+	".zip":  "application/zip",
+}
+
+func MimeType(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	if t, ok := mediaTypes[ext]; ok {
+		return t
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
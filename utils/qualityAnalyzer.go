@@ -1,7 +1,9 @@
 package util
 
 import (
+	"fmt"
 	"log"
+	"strconv"
 	"strings"
 )
 
@@ -56,26 +58,59 @@ func CheckAndPickFormat(requestedQuality string, platfromInfo string) (string, s
 	return finalFormat, "matched"
 }
 
-func GetFragmentsByQuality(quality string) string {
+// GetFragmentsByQuality is the most fragments a quality benefits from,
+// package fragments lowers it under load
+func GetFragmentsByQuality(quality string) int {
 
+	// Highest first, "1440" contains "144"
 	switch {
-	case strings.Contains(quality, "144"):
-		return "2"
-	case strings.Contains(quality, "240"):
-		return "2"
-	case strings.Contains(quality, "360"):
-		return "4"
-	case strings.Contains(quality, "480"):
-		return "6"
-	case strings.Contains(quality, "720"):
-		return "8"
-	case strings.Contains(quality, "1080"):
-		return "12"
-	case strings.Contains(quality, "1440"):
-		return "16"
 	case strings.Contains(quality, "2160"):
-		return "16"
+		return 16
+	case strings.Contains(quality, "1440"):
+		return 16
+	case strings.Contains(quality, "1080"):
+		return 12
+	case strings.Contains(quality, "720"):
+		return 8
+	case strings.Contains(quality, "480"):
+		return 6
+	case strings.Contains(quality, "360"):
+		return 4
+	case strings.Contains(quality, "240"):
+		return 2
+	case strings.Contains(quality, "144"):
+		return 2
 	default:
-		return "6"
+		return 6
+	}
+}
+
+// PickLiveFormat returns a muxed format for live streams, HLS live formats can't be merged on the fly
+func PickLiveFormat(requestedQuality string) string {
+	for _, q := range qualityOrder {
+		if q == requestedQuality {
+			return fmt.Sprintf("b[height<=%s]/b", strings.TrimSuffix(q, "p"))
+		}
+	}
+	return "b"
+}
+
+// QualityHeight is the height a quality like "1080p" stands for, 0 when unknown
+func QualityHeight(quality string) int {
+	if _, ok := qualityFormatMap[quality]; !ok {
+		return 0
+	}
+	height, _ := strconv.Atoi(strings.TrimSuffix(quality, "p"))
+	return height
+}
+
+// StepDownFormat drops the first alternative of a format chain, so a failing
+// "bv*[height<=1080]+ba" becomes the muxed "b[height<=1080]", then 720p and
+// so on. The last alternative is kept.
+func StepDownFormat(chain string) string {
+	alternatives := strings.Split(chain, "/")
+	if len(alternatives) <= 1 {
+		return chain
 	}
+	return strings.Join(alternatives[1:], "/")
 }
@@ -0,0 +1,38 @@
+package util
+
+import "testing"
+
+func TestStepDownFormat(t *testing.T) {
+	chain, _ := CheckAndPickFormat("720p", "")
+
+	// Each step drops one alternative, merged before muxed, until the last
+	steps := []string{
+		"bv*[height<=720]+ba/b[height<=720]/bv*[height<=480]+ba/b[height<=480]/bv*[height<=360]+ba/b[height<=360]/bv*[height<=240]+ba/b[height<=240]/bv*[height<=144]+ba/b[height<=144]/bv*+ba/b",
+		"b[height<=720]/bv*[height<=480]+ba/b[height<=480]/bv*[height<=360]+ba/b[height<=360]/bv*[height<=240]+ba/b[height<=240]/bv*[height<=144]+ba/b[height<=144]/bv*+ba/b",
+		"bv*[height<=480]+ba/b[height<=480]/bv*[height<=360]+ba/b[height<=360]/bv*[height<=240]+ba/b[height<=240]/bv*[height<=144]+ba/b[height<=144]/bv*+ba/b",
+	}
+	if chain != steps[0] {
+		t.Fatalf("chain = %q, want %q", chain, steps[0])
+	}
+	for i := 1; i < len(steps); i++ {
+		chain = StepDownFormat(chain)
+		if chain != steps[i] {
+			t.Fatalf("step %d = %q, want %q", i, chain, steps[i])
+		}
+	}
+
+	for range 20 {
+		chain = StepDownFormat(chain)
+	}
+	if chain != "b" {
+		t.Fatalf("chain after every step = %q, want the last alternative", chain)
+	}
+}
+
+func TestStepDownFormatSingle(t *testing.T) {
+	for _, chain := range []string{"b", "bv*+ba", ""} {
+		if got := StepDownFormat(chain); got != chain {
+			t.Errorf("StepDownFormat(%q) = %q, want it unchanged", chain, got)
+		}
+	}
+}
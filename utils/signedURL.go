@@ -0,0 +1,53 @@
+package util
+
+import (
+	"backend/config"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrLinkExpired = errors.New("download link expired")
+	ErrLinkInvalid = errors.New("download link signature invalid")
+)
+
+// SignedDownloadURL returns DownloadURL with an expiry and HMAC signature.
+// A once link is spent by the first request that gets all of the file, or
+// of the range it asked for, see jobs.ClaimLink.
+func SignedDownloadURL(requestID, name string, expires int64, once bool) string {
+	query := fmt.Sprintf("?expires=%d&sig=%s", expires, signDownload(requestID, name, expires, once))
+	if once {
+		query += "&once=1"
+	}
+	return DownloadURL(requestID, name) + query
+}
+
+// VerifyDownloadLink checks the signature and expiry of a download link
+func VerifyDownloadLink(requestID, name, expiresParam, sig string, once bool) error {
+	expires, err := strconv.ParseInt(expiresParam, 10, 64)
+	if err != nil || sig == "" {
+		return ErrLinkInvalid
+	}
+
+	expected := signDownload(requestID, name, expires, once)
+	if !hmac.Equal([]byte(expected), []byte(sig)) {
+		return ErrLinkInvalid
+	}
+
+	if time.Now().Unix() >= expires {
+		return ErrLinkExpired
+	}
+
+	return nil
+}
+
+func signDownload(requestID, name string, expires int64, once bool) string {
+	mac := hmac.New(sha256.New, config.Get().DownloadSigningKey)
+	fmt.Fprintf(mac, "%s\n%s\n%d\n%t", requestID, name, expires, once)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -7,6 +7,8 @@ import (
 )
 
 type platformRule struct {
+	channelLive       bool // the bare channel URL, e.g. twitch.tv/name, is its live stream
+	livePaths         []string
 	reelPaths         []string
 	videoPaths        []string
 	defaultType       models.VideoType
@@ -75,8 +77,9 @@ var hostToPlatform = map[string]string{
 
 var platformRules = map[string]platformRule{
 	"YouTube": {
+		livePaths:         []string{"/live"},
 		reelPaths:         []string{"/shorts"},
-		videoPaths:        []string{"/watch", "/embed", "/live"},
+		videoPaths:        []string{"/watch", "/embed"},
 		defaultType:       models.VideoTypeVideo,
 		defaultConfidence: models.ConfidenceMedium,
 	},
@@ -93,6 +96,7 @@ var platformRules = map[string]platformRule{
 		defaultConfidence: models.ConfidenceMedium,
 	},
 	"TikTok": {
+		livePaths:         []string{"/live"},
 		reelPaths:         []string{},
 		videoPaths:        []string{},
 		defaultType:       models.VideoTypeReel,
@@ -105,6 +109,7 @@ var platformRules = map[string]platformRule{
 		defaultConfidence: models.ConfidenceHigh,
 	},
 	"Twitch": {
+		channelLive:       true,
 		reelPaths:         []string{"/clip", "/clips"},
 		videoPaths:        []string{"/videos"},
 		defaultType:       models.VideoTypeVideo,
@@ -199,6 +204,16 @@ func DetectPlatform(inputURL string) models.PlatformInfo {
 
 	path := strings.ToLower(parsed.Path)
 
+	for _, livePath := range rule.livePaths {
+		if strings.Contains(path, strings.ToLower(livePath)) {
+			return models.PlatformInfo{
+				Platform:   platform,
+				VideoType:  models.VideoTypeLive,
+				Confidence: models.ConfidenceHigh,
+			}
+		}
+	}
+
 	for _, reelPath := range rule.reelPaths {
 		if strings.Contains(path, strings.ToLower(reelPath)) {
 			return models.PlatformInfo{
@@ -225,3 +240,45 @@ func DetectPlatform(inputURL string) models.PlatformInfo {
 		Confidence: rule.defaultConfidence,
 	}
 }
+
+// MayBeLive reports whether the path of inputURL points at a live stream, a
+// /live path or a channel URL. Whether it runs right now takes yt-dlp. A
+// plain video link that turns out to be live is caught when the download
+// resolves its formats.
+func MayBeLive(inputURL string) bool {
+	info := DetectPlatform(inputURL)
+	if info.VideoType == models.VideoTypeLive {
+		return true
+	}
+	if !platformRules[info.Platform].channelLive {
+		return false
+	}
+
+	parsed, err := url.Parse(inputURL)
+	if err != nil {
+		return false
+	}
+	path := strings.Trim(parsed.Path, "/")
+	return path != "" && !strings.Contains(path, "/")
+}
+
+// PlatformDomains returns the hosts DetectPlatform maps to platform
+func PlatformDomains(platform string) []string {
+	var domains []string
+	for host, name := range hostToPlatform {
+		if name == platform {
+			domains = append(domains, host)
+		}
+	}
+	return domains
+}
+
+// CanonicalPlatform matches a platform name case-insensitively, "youtube" gives "YouTube"
+func CanonicalPlatform(name string) (string, bool) {
+	for _, platform := range hostToPlatform {
+		if strings.EqualFold(platform, name) {
+			return platform, true
+		}
+	}
+	return "", false
+}
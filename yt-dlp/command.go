@@ -0,0 +1,456 @@
+package ytdlp
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Session is how yt-dlp identifies itself to the platform
+type Session struct {
+	CookiesFile    string // Netscape cookies.txt, wins over CookiesBrowser
+	CookiesBrowser string
+	Proxy          string // "" connects directly
+	Profile        Profile
+}
+
+// Profile holds the platform specific options a platform needs to keep
+// working, e.g. YouTube player clients or TikTok browser impersonation
+type Profile struct {
+	ExtractorArgs []string          `json:"extractor_args,omitempty"` // e.g. "youtube:player_client=web,android"
+	Headers       map[string]string `json:"headers,omitempty"`
+	UserAgent     string            `json:"user_agent,omitempty"`
+	Impersonate   string            `json:"impersonate,omitempty"` // e.g. "chrome" or "safari-17.0"
+
+	// Seconds between extraction requests and between downloads, a random
+	// wait up to MaxSleepInterval when that is set
+	SleepRequests    float64 `json:"sleep_requests,omitempty"`
+	SleepInterval    float64 `json:"sleep_interval,omitempty"`
+	MaxSleepInterval float64 `json:"max_sleep_interval,omitempty"`
+}
+
+// Validate reports settings yt-dlp would reject
+func (p Profile) Validate() error {
+	var errs []error
+
+	if p.SleepRequests < 0 || p.SleepInterval < 0 || p.MaxSleepInterval < 0 {
+		errs = append(errs, errors.New("sleep intervals can't be negative"))
+	}
+	if p.MaxSleepInterval > 0 && p.MaxSleepInterval < p.SleepInterval {
+		errs = append(errs, errors.New("max sleep interval is below the sleep interval"))
+	}
+	if p.MaxSleepInterval > 0 && p.SleepInterval == 0 {
+		errs = append(errs, errors.New("max sleep interval needs a sleep interval"))
+	}
+	for name := range p.Headers {
+		if name == "" || strings.ContainsAny(name, ": \r\n") {
+			errs = append(errs, fmt.Errorf("invalid header name %q", name))
+		}
+	}
+	for _, arg := range p.ExtractorArgs {
+		if !strings.Contains(arg, ":") {
+			errs = append(errs, fmt.Errorf("extractor args %q need an \"extractor:\" prefix", arg))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Command builds yt-dlp argv. Setters can be chained and Build checks
+// that the options don't contradict each other:
+//
+//	args, err := ytdlp.NewCommand(url).Format("bv*+ba/b").Output(tpl).Build()
+type Command struct {
+	url string
+
+	dumpJSON           bool
+	quiet              bool
+	noWarnings         bool
+	noCheckCertificate bool
+	newline            bool
+	printFinalPath     bool
+
+	cookiesBrowser string
+	cookiesFile    string
+	proxy          string
+	profile        Profile
+
+	format            string
+	mergeOutputFormat string
+	output            string
+
+	continueDownload    bool
+	noPart              bool
+	hlsUseMpegTS        bool
+	liveFromStart       bool
+	concurrentFragments int
+	rateLimit           string
+	maxFileSize         int64
+	matchFilters        []string
+	sections            []string
+
+	subLangs     []string
+	embedSubs    bool
+	extractAudio string
+	remuxVideo   string
+}
+
+// NewCommand starts a command for a single video, without cookies
+func NewCommand(url string) *Command {
+	return &Command{url: url}
+}
+
+// DumpJSON prints the video metadata instead of downloading (-j)
+func (c *Command) DumpJSON() *Command {
+	c.dumpJSON = true
+	return c
+}
+
+// Quiet silences everything but errors, warnings included
+func (c *Command) Quiet() *Command {
+	c.quiet = true
+	c.noWarnings = true
+	return c
+}
+
+func (c *Command) NoCheckCertificate() *Command {
+	c.noCheckCertificate = true
+	return c
+}
+
+// Progress prints one progress line per update, the runner parses them
+func (c *Command) Progress() *Command {
+	c.newline = true
+	return c
+}
+
+// PrintFinalPath reports the file left after post-processing, see RunYTDownloadWithProgress
+func (c *Command) PrintFinalPath() *Command {
+	c.printFinalPath = true
+	return c
+}
+
+// CookiesFromBrowser replaces any cookie source with the browser's cookie store
+func (c *Command) CookiesFromBrowser(browser string) *Command {
+	c.cookiesBrowser = browser
+	c.cookiesFile = ""
+	return c
+}
+
+// CookiesFile replaces any cookie source with a Netscape cookies.txt
+func (c *Command) CookiesFile(path string) *Command {
+	c.cookiesFile = path
+	c.cookiesBrowser = ""
+	return c
+}
+
+// NoCookies sends requests without any cookies
+func (c *Command) NoCookies() *Command {
+	c.cookiesFile = ""
+	c.cookiesBrowser = ""
+	return c
+}
+
+// Session applies the cookies, proxy and profile of s, replacing any cookie source
+func (c *Command) Session(s Session) *Command {
+	c.proxy = s.Proxy
+	c.profile = s.Profile
+
+	switch {
+	case s.CookiesFile != "":
+		return c.CookiesFile(s.CookiesFile)
+	case s.CookiesBrowser != "":
+		return c.CookiesFromBrowser(s.CookiesBrowser)
+	default:
+		return c.NoCookies()
+	}
+}
+
+func (c *Command) Proxy(proxy string) *Command {
+	c.proxy = proxy
+	return c
+}
+
+// Format sets the format selector, e.g. "bv*[height<=720]+ba/b"
+func (c *Command) Format(format string) *Command {
+	c.format = format
+	return c
+}
+
+// MergeOutputFormat is the container used when video and audio are merged
+func (c *Command) MergeOutputFormat(ext string) *Command {
+	c.mergeOutputFormat = ext
+	return c
+}
+
+// Output sets the output template, e.g. "downloads/<id>/title.%(ext)s", "-" writes to stdout
+func (c *Command) Output(template string) *Command {
+	c.output = template
+	return c
+}
+
+// Continue resumes partially downloaded files
+func (c *Command) Continue() *Command {
+	c.continueDownload = true
+	return c
+}
+
+// NoPart writes straight into the output file, needed for live recordings
+func (c *Command) NoPart() *Command {
+	c.noPart = true
+	return c
+}
+
+func (c *Command) HLSUseMpegTS() *Command {
+	c.hlsUseMpegTS = true
+	return c
+}
+
+// LiveFromStart records a live stream from its beginning when the platform allows it
+func (c *Command) LiveFromStart() *Command {
+	c.liveFromStart = true
+	return c
+}
+
+func (c *Command) ConcurrentFragments(n int) *Command {
+	c.concurrentFragments = n
+	return c
+}
+
+// RateLimit caps the download speed, e.g. "5M" for 5 MiB/s
+func (c *Command) RateLimit(rate string) *Command {
+	c.rateLimit = rate
+	return c
+}
+
+// MaxFileSize skips formats larger than bytes instead of downloading them
+func (c *Command) MaxFileSize(bytes int64) *Command {
+	c.maxFileSize = bytes
+	return c
+}
+
+// MatchFilter skips videos that don't pass filter, e.g. "duration<=?3600"
+func (c *Command) MatchFilter(filter string) *Command {
+	c.matchFilters = append(c.matchFilters, filter)
+	return c
+}
+
+// Section downloads only start-end, either may be empty for the video start or end
+func (c *Command) Section(start, end string) *Command {
+	if start == "" {
+		start = "0"
+	}
+	if end == "" {
+		end = "inf"
+	}
+	c.sections = append(c.sections, fmt.Sprintf("*%s-%s", start, end))
+	return c
+}
+
+// Subtitles downloads the subtitles in langs, embed puts them into the video file
+func (c *Command) Subtitles(embed bool, langs ...string) *Command {
+	c.subLangs = append(c.subLangs, langs...)
+	c.embedSubs = embed
+	return c
+}
+
+// ExtractAudio converts the download to an audio file of format, e.g. "mp3"
+func (c *Command) ExtractAudio(format string) *Command {
+	c.extractAudio = format
+	return c
+}
+
+// RemuxVideo changes the container of the finished video without re-encoding
+func (c *Command) RemuxVideo(ext string) *Command {
+	c.remuxVideo = ext
+	return c
+}
+
+// Validate reports options that can't be used together
+func (c *Command) Validate() error {
+	var errs []error
+
+	if c.url == "" {
+		errs = append(errs, errors.New("url is required"))
+	}
+	if c.dumpJSON && c.printFinalPath {
+		errs = append(errs, errors.New("dump json doesn't download, there is no final path to print"))
+	}
+	if c.dumpJSON && (c.extractAudio != "" || c.remuxVideo != "" || c.embedSubs) {
+		errs = append(errs, errors.New("dump json doesn't download, post-processors would never run"))
+	}
+	if c.extractAudio != "" && (c.mergeOutputFormat != "" || c.remuxVideo != "") {
+		errs = append(errs, errors.New("extract audio can't be combined with a video container"))
+	}
+	if c.extractAudio != "" && c.embedSubs {
+		errs = append(errs, errors.New("subtitles can't be embedded into an audio file"))
+	}
+	if c.liveFromStart && len(c.sections) > 0 {
+		errs = append(errs, errors.New("live from start can't be combined with sections"))
+	}
+	if c.output == "-" && (c.printFinalPath || c.extractAudio != "" || c.remuxVideo != "" || c.embedSubs ||
+		c.mergeOutputFormat != "" || strings.Contains(c.format, "+")) {
+		errs = append(errs, errors.New("writing to stdout leaves no file to merge or post-process"))
+	}
+	if c.noPart && c.continueDownload {
+		errs = append(errs, errors.New("continue needs .part files, it can't be combined with no part"))
+	}
+	if c.maxFileSize < 0 {
+		errs = append(errs, fmt.Errorf("invalid max file size: %d", c.maxFileSize))
+	}
+	if c.concurrentFragments < 0 {
+		errs = append(errs, fmt.Errorf("invalid concurrent fragments: %d", c.concurrentFragments))
+	}
+	if err := c.profile.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("invalid profile: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Build validates the command and returns its argv without the binary, URL last
+func (c *Command) Build() ([]string, error) {
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid yt-dlp command: %w", err)
+	}
+
+	var args []string
+
+	if c.dumpJSON {
+		args = append(args, "-j")
+	}
+	args = append(args, "--no-playlist")
+
+	switch {
+	case c.cookiesFile != "":
+		args = append(args, "--cookies", c.cookiesFile)
+	case c.cookiesBrowser != "":
+		args = append(args, "--cookies-from-browser", c.cookiesBrowser)
+	}
+	if c.proxy != "" {
+		args = append(args, "--proxy", c.proxy)
+	}
+	args = append(args, c.profile.args()...)
+
+	if c.noWarnings {
+		args = append(args, "--no-warnings")
+	}
+	if c.noCheckCertificate {
+		args = append(args, "--no-check-certificate")
+	}
+	if c.quiet {
+		args = append(args, "--quiet")
+	}
+	if c.newline {
+		args = append(args, "--newline")
+	}
+
+	if c.continueDownload {
+		args = append(args, "--continue")
+	}
+	if c.noPart {
+		args = append(args, "--no-part")
+	}
+	if c.hlsUseMpegTS {
+		args = append(args, "--hls-use-mpegts")
+	}
+	if c.liveFromStart {
+		args = append(args, "--live-from-start")
+	}
+
+	if c.format != "" {
+		args = append(args, "-f", c.format)
+	}
+	if c.output != "" {
+		args = append(args, "-o", c.output)
+	}
+	if c.concurrentFragments > 0 {
+		args = append(args, "--concurrent-fragments", strconv.Itoa(c.concurrentFragments))
+	}
+	if c.rateLimit != "" {
+		args = append(args, "--limit-rate", c.rateLimit)
+	}
+	if c.maxFileSize > 0 {
+		args = append(args, "--max-filesize", strconv.FormatInt(c.maxFileSize, 10))
+	}
+	for _, filter := range c.matchFilters {
+		args = append(args, "--match-filter", filter)
+	}
+	for _, section := range c.sections {
+		args = append(args, "--download-sections", section)
+	}
+
+	if len(c.subLangs) > 0 {
+		args = append(args, "--write-subs", "--sub-langs", strings.Join(c.subLangs, ","))
+		if c.embedSubs {
+			args = append(args, "--embed-subs")
+		}
+	}
+	if c.extractAudio != "" {
+		args = append(args, "--extract-audio", "--audio-format", c.extractAudio)
+	}
+	if c.mergeOutputFormat != "" {
+		args = append(args, "--merge-output-format", c.mergeOutputFormat)
+	}
+	if c.remuxVideo != "" {
+		args = append(args, "--remux-video", c.remuxVideo)
+	}
+
+	// --print implies --quiet, --progress brings the progress lines back.
+	// Quiet also hides why a video was skipped, the before_dl line tells a
+	// --match-filter skip from a --max-filesize one.
+	if c.printFinalPath {
+		args = append(args,
+			"--print", "before_dl:"+startedPrefix+"%(format_id)s",
+			"--print", "after_move:"+finalPathPrefix+"%(filepath)s",
+			"--progress",
+		)
+	}
+
+	// A URL starting with "-" must not be read as an option
+	args = append(args, "--", c.url)
+
+	return args, nil
+}
+
+func (p Profile) args() []string {
+	var args []string
+
+	for _, extractorArgs := range p.ExtractorArgs {
+		args = append(args, "--extractor-args", extractorArgs)
+	}
+	if p.UserAgent != "" {
+		args = append(args, "--user-agent", p.UserAgent)
+	}
+
+	names := make([]string, 0, len(p.Headers))
+	for name := range p.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, "--add-header", name+":"+p.Headers[name])
+	}
+
+	if p.Impersonate != "" {
+		args = append(args, "--impersonate", p.Impersonate)
+	}
+
+	if p.SleepRequests > 0 {
+		args = append(args, "--sleep-requests", formatSeconds(p.SleepRequests))
+	}
+	if p.SleepInterval > 0 {
+		args = append(args, "--sleep-interval", formatSeconds(p.SleepInterval))
+	}
+	if p.MaxSleepInterval > 0 {
+		args = append(args, "--max-sleep-interval", formatSeconds(p.MaxSleepInterval))
+	}
+
+	return args
+}
+
+func formatSeconds(s float64) string {
+	return strconv.FormatFloat(s, 'f', -1, 64)
+}
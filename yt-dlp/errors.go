@@ -0,0 +1,149 @@
+package ytdlp
+
+import (
+	"errors"
+	"strings"
+
+	"backend/proc"
+)
+
+// ErrorCode is a stable identifier of why yt-dlp failed, clients may switch on it
+type ErrorCode string
+
+const (
+	CodePrivate           ErrorCode = "private_video"
+	CodeAgeRestricted     ErrorCode = "age_restricted"
+	CodeGeoBlocked        ErrorCode = "geo_blocked"
+	CodeRemoved           ErrorCode = "removed"
+	CodeLoginRequired     ErrorCode = "login_required"
+	CodeRateLimited       ErrorCode = "rate_limited"
+	CodeUnsupportedURL    ErrorCode = "unsupported_url"
+	CodeFormatUnavailable ErrorCode = "format_unavailable"
+	CodeFFmpegMissing     ErrorCode = "ffmpeg_missing"
+	CodeDiskFull          ErrorCode = "disk_full"
+	CodeNetwork           ErrorCode = "network_error"
+	CodeTooLarge          ErrorCode = "file_too_large"
+	CodeTooLong           ErrorCode = "too_long"
+	CodeTimeout           ErrorCode = "timeout"
+	CodeUnknown           ErrorCode = "unknown"
+)
+
+// Error is a classified yt-dlp failure. Message is safe to show to users,
+// Error() keeps yt-dlp's own output for the logs.
+type Error struct {
+	Code      ErrorCode
+	Message   string
+	Retryable bool
+	Detail    string // yt-dlp's error lines
+	Err       error
+}
+
+func (e *Error) Error() string {
+	if e.Detail == "" {
+		return "yt-dlp failed: " + e.Err.Error()
+	}
+	return "yt-dlp failed: " + e.Err.Error() + " | " + e.Detail
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+type errorClass struct {
+	code      ErrorCode
+	message   string
+	retryable bool
+	patterns  []string
+}
+
+// Checked in order, the first match wins: "Sign in to confirm your age"
+// is an age restriction, not a plain login wall
+var errorClasses = []errorClass{
+	{CodeTooLarge, "This video is larger than the server allows", false,
+		[]string{"larger than max-filesize"}},
+	{CodeTooLong, "This video is longer than the server allows", false,
+		[]string{"does not pass filter (duration"}},
+	{CodeDiskFull, "The server ran out of disk space. Please try again later", true,
+		[]string{"no space left on device", "errno 28", "disk quota exceeded"}},
+	{CodeFFmpegMissing, "The server is missing ffmpeg and can't process this video", false,
+		[]string{"ffmpeg is not installed", "ffmpeg not found", "ffprobe and ffmpeg not found", "ffprobe/avprobe and ffmpeg/avconv not found"}},
+	{CodeAgeRestricted, "This video is age-restricted", false,
+		[]string{"confirm your age", "age-restricted", "age restricted", "inappropriate for some users"}},
+	{CodePrivate, "This video is private", false,
+		[]string{"private video", "this video is private", "video is private", "this account is private"}},
+	{CodeGeoBlocked, "This video is not available in the server's region", false,
+		[]string{"available in your country", "geo restriction", "geo-restricted", "geo restricted", "blocked it in your country", "not available from your location"}},
+	{CodeLoginRequired, "This video requires a logged in account", false,
+		[]string{"sign in to confirm", "login required", "log in for access", "requires authentication", "use --cookies", "only available for registered users", "login to view"}},
+	{CodeRateLimited, "The platform is rate limiting us. Please try again in a few minutes", true,
+		[]string{"http error 429", "too many requests", "rate-limit", "rate limit"}},
+	{CodeRemoved, "This video was removed or does not exist", false,
+		[]string{"video unavailable", "has been removed", "no longer available", "has been terminated", "does not exist", "http error 404", "404: not found", "this content isn't available"}},
+	{CodeUnsupportedURL, "This link is not supported", false,
+		[]string{"unsupported url", "is not a valid url"}},
+	{CodeFormatUnavailable, "The requested quality is not available for this video", false,
+		[]string{"requested format is not available", "no video formats found", "no formats found"}},
+	{CodeNetwork, "A network error occurred. Please try again", true,
+		[]string{"timed out", "connection reset", "connection refused", "temporary failure in name resolution", "unable to download webpage", "network is unreachable", "remote end closed connection", "http error 5"}},
+}
+
+var timeoutClass = errorClass{CodeTimeout, "This took too long and was stopped. Please try again later", false, nil}
+
+// Classify turns a failed run into an *Error, output is the stderr or error
+// lines of yt-dlp or of another downloader
+func Classify(err error, output string) *Error {
+	detail := strings.TrimSpace(output)
+	msg := strings.ToLower(detail)
+
+	if errors.Is(err, proc.ErrTimeout) {
+		return timeoutClass.newError(err, detail)
+	}
+
+	for _, class := range errorClasses {
+		for _, pattern := range class.patterns {
+			if strings.Contains(msg, pattern) {
+				return class.newError(err, detail)
+			}
+		}
+	}
+
+	return &Error{
+		Code:      CodeUnknown,
+		Message:   "Download failed",
+		Retryable: true,
+		Detail:    detail,
+		Err:       err,
+	}
+}
+
+// NewError classifies err as code, for limits the server checks before running yt-dlp
+func NewError(code ErrorCode, err error) *Error {
+	if code == CodeTimeout {
+		return timeoutClass.newError(err, "")
+	}
+	for _, class := range errorClasses {
+		if class.code == code {
+			return class.newError(err, "")
+		}
+	}
+	return &Error{Code: code, Message: "Download failed", Err: err}
+}
+
+func (class errorClass) newError(err error, detail string) *Error {
+	return &Error{
+		Code:      class.code,
+		Message:   class.message,
+		Retryable: class.retryable,
+		Detail:    detail,
+		Err:       err,
+	}
+}
+
+// CodeOf returns the classification of err, "" when err is not a yt-dlp failure
+func CodeOf(err error) ErrorCode {
+	var ytErr *Error
+	if errors.As(err, &ytErr) {
+		return ytErr.Code
+	}
+	return ""
+}
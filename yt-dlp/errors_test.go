@@ -0,0 +1,77 @@
+package ytdlp
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"backend/proc"
+)
+
+// Lines as yt-dlp prints them
+func TestClassify(t *testing.T) {
+	exit := errors.New("exit status 1")
+
+	tests := []struct {
+		name      string
+		output    string
+		code      ErrorCode
+		retryable bool
+	}{
+		{"private", "ERROR: [youtube] dQw4w9WgXcQ: Private video. Sign in if you've been granted access to this video", CodePrivate, false},
+		{"age", "ERROR: [youtube] dQw4w9WgXcQ: Sign in to confirm your age. This video may be inappropriate for some users.", CodeAgeRestricted, false},
+		{"bot check", "ERROR: [youtube] dQw4w9WgXcQ: Sign in to confirm you’re not a bot. Use --cookies-from-browser or --cookies for the authentication. See  https://github.com/yt-dlp/yt-dlp/wiki/FAQ#how-do-i-pass-cookies-to-yt-dlp  for how to manually pass cookies. Also see  https://github.com/yt-dlp/yt-dlp/wiki/Extractors#exporting-youtube-cookies  for tips on effectively exporting YouTube cookies", CodeLoginRequired, false},
+		{"registered users", "ERROR: [facebook] 1234567890: This video is only available for registered users. Use --cookies, --cookies-from-browser, --username and --password, --netrc-cmd, or --netrc (facebook) to provide account credentials", CodeLoginRequired, false},
+		{"geo", "ERROR: [youtube] dQw4w9WgXcQ: Video unavailable. The uploader has not made this video available in your country", CodeGeoBlocked, false},
+		{"geo restriction", "ERROR: [BBCiPlayer] p0abcdef: This video is not available from your location due to geo restriction", CodeGeoBlocked, false},
+		{"removed", "ERROR: [youtube] dQw4w9WgXcQ: Video unavailable. This video has been removed by the uploader", CodeRemoved, false},
+		{"terminated", "ERROR: [youtube] dQw4w9WgXcQ: Video unavailable. This video is no longer available because the YouTube account associated with this video has been terminated.", CodeRemoved, false},
+		{"not found", "ERROR: [vimeo] 123456: Unable to download webpage: HTTP Error 404: Not Found (caused by <HTTPError 404: Not Found>)", CodeRemoved, false},
+		{"unsupported", "ERROR: Unsupported URL: https://example.com/page", CodeUnsupportedURL, false},
+		{"format", "ERROR: [youtube] dQw4w9WgXcQ: Requested format is not available. Use --list-formats for a list of available formats", CodeFormatUnavailable, false},
+		{"rate limited", "ERROR: [youtube] dQw4w9WgXcQ: Unable to download API page: HTTP Error 429: Too Many Requests (caused by <HTTPError 429: Too Many Requests>)", CodeRateLimited, true},
+		{"dns", "ERROR: [youtube] dQw4w9WgXcQ: Unable to download webpage: <urlopen error [Errno -3] Temporary failure in name resolution> (caused by TransportError('<urlopen error [Errno -3] Temporary failure in name resolution>'))", CodeNetwork, true},
+		{"connection reset", "ERROR: unable to download video data: ('Connection aborted.', ConnectionResetError(104, 'Connection reset by peer'))", CodeNetwork, true},
+		{"server error", "ERROR: unable to download video data: HTTP Error 503: Service Unavailable", CodeNetwork, true},
+		{"ffmpeg merge", "ERROR: You have requested merging of multiple formats but ffmpeg is not installed. Aborting due to --abort-on-error", CodeFFmpegMissing, false},
+		{"ffmpeg post-processing", "ERROR: Postprocessing: ffprobe and ffmpeg not found. Please install or provide the path using --ffmpeg-location", CodeFFmpegMissing, false},
+		{"disk full", "ERROR: unable to write data: [Errno 28] No space left on device", CodeDiskFull, true},
+		{"max filesize", "[download] File is larger than max-filesize (52428800 bytes > 10485760 bytes). Aborting.", CodeTooLarge, false},
+		{"match filter", "[download] dQw4w9WgXcQ does not pass filter (duration <= 3600), skipping ..", CodeTooLong, false},
+		{"unknown", "ERROR: [youtube] dQw4w9WgXcQ: This live event will begin in 3 hours.", CodeUnknown, true},
+		{"no output", "", CodeUnknown, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Classify(exit, tt.output)
+			if got.Code != tt.code {
+				t.Fatalf("code = %s, want %s", got.Code, tt.code)
+			}
+			if got.Retryable != tt.retryable {
+				t.Fatalf("retryable = %v, want %v", got.Retryable, tt.retryable)
+			}
+			if !errors.Is(got, exit) {
+				t.Fatal("classified error doesn't wrap the exit error")
+			}
+		})
+	}
+}
+
+func TestClassifyTimeout(t *testing.T) {
+	// The output of a killed run is whatever it printed so far
+	err := Classify(fmt.Errorf("yt-dlp: %w", proc.ErrTimeout), "ERROR: unable to download video data: HTTP Error 503")
+	if err.Code != CodeTimeout {
+		t.Fatalf("code = %s, want %s", err.Code, CodeTimeout)
+	}
+}
+
+func TestCodeOf(t *testing.T) {
+	wrapped := fmt.Errorf("yt-dlp execution failed: %w", NewError(CodeTooLong, errSkipped))
+	if got := CodeOf(wrapped); got != CodeTooLong {
+		t.Fatalf("CodeOf(wrapped) = %s, want %s", got, CodeTooLong)
+	}
+	if got := CodeOf(errors.New("plain")); got != "" {
+		t.Fatalf("CodeOf(plain) = %q, want none", got)
+	}
+}
@@ -1,45 +1,51 @@
 package ytdlp
 
 import (
+	"backend/config"
+	"backend/deps"
+	"backend/joblog"
 	"backend/models"
+	"backend/proc"
 	sse "backend/sse"
 	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os/exec"
+	"io"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 )
 
-func GetVideoInfoFromYTDLP(videoURL string) (*models.VideoInfo, error) {
-	binary, err := exec.LookPath("yt-dlp")
+// GetVideoInfoFromYTDLP reads the metadata of videoURL, a hung extractor is killed after INFO_TIMEOUT
+func GetVideoInfoFromYTDLP(ctx context.Context, videoURL string, session Session) (*models.VideoInfo, error) {
+	binary, err := deps.Path(deps.YTDLP)
 	if err != nil {
-		return nil, fmt.Errorf("yt-dlp binary not found: %w", err)
+		return nil, err
 	}
 
-	cmd := exec.Command(
-		binary,
-		"-j",
-		"--no-playlist",
-		"--cookies-from-browser", "firefox",
-		"--no-warnings",
-		"--no-check-certificate",
-		"--quiet",
-		videoURL,
-	)
+	args, err := NewCommand(videoURL).
+		Session(session).
+		DumpJSON().
+		Quiet().
+		NoCheckCertificate().
+		Build()
+	if err != nil {
+		return nil, err
+	}
 
-	fmt.Printf("[yt-dlp CMD] %s\n", strings.Join(cmd.Args, " "))
+	cmd := proc.CommandContext(ctx, proc.Options{Timeout: config.Get().InfoTimeout}, binary, args...)
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
 	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("yt-dlp exec error: %w | stderr: %s", err, stderr.String())
+		return nil, Classify(err, stderr.String())
 	}
 
 	var data models.YtdlpInfo
@@ -56,32 +62,68 @@ func GetVideoInfoFromYTDLP(videoURL string) (*models.VideoInfo, error) {
 		LikeCount:   data.LikeCount,
 		VideoPage:   videoURL,
 		Source:      "yt-dlp",
+		IsLive:      data.IsLive || data.LiveStatus == "is_live",
+		LiveStatus:  data.LiveStatus,
+		Duration:    data.Duration,
 	}
 
 	return videoInfo, nil
 }
-func RunYTDownloadWithProgress(ctx context.Context, args []string, requestID string) error {
 
-	cmd := exec.CommandContext(ctx, "yt-dlp", args...)
+// Command.PrintFinalPath makes yt-dlp print a line with startedPrefix before
+// downloading a format and one with finalPathPrefix once its file is in place
+const (
+	startedPrefix   = "STARTED:"
+	finalPathPrefix = "FINALPATH:"
+)
+
+// errSkipped is the cause of a run where yt-dlp exited cleanly without
+// downloading, because of --max-filesize or --match-filter
+var errSkipped = errors.New("download skipped")
+
+// RunYTDownloadWithProgress runs yt-dlp and forwards its progress over SSE.
+// It returns the final file paths when the command used PrintFinalPath, one
+// per format downloaded, nil otherwise, and what the run transferred.
+// timeout 0 runs until ctx is done.
+func RunYTDownloadWithProgress(ctx context.Context, args []string, requestID string, timeout time.Duration) ([]string, Transfer, error) {
+
+	binary, err := deps.Path(deps.YTDLP)
+	if err != nil {
+		return nil, Transfer{}, err
+	}
+
+	// Interrupt instead of kill so yt-dlp can finalize what it already wrote
+	cmd := proc.CommandContext(ctx, proc.Options{Timeout: timeout, Graceful: true}, binary, args...)
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return fmt.Errorf("stdout pipe error: %w", err)
+		return nil, Transfer{}, fmt.Errorf("stdout pipe error: %w", err)
 	}
 
 	cmd.Stderr = cmd.Stdout
 
 	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("yt-dlp start failed: %w", err)
+		return nil, Transfer{}, fmt.Errorf("yt-dlp start failed: %w", err)
 	}
 
+	// Every line goes to the job's log, stdout would mix up concurrent jobs
+	jobLog := joblog.From(ctx)
+
 	reader := bufio.NewReader(stdout)
 
 	percentRegex := regexp.MustCompile(`(\d{1,3}(?:\.\d+)?)%`)
 	sizeRegex := regexp.MustCompile(`of\s+~?\s*([\d\.]+\s*[KMG]i?B)`)
+	elapsedRegex := regexp.MustCompile(`time=(\d{2}:\d{2}:\d{2})`)
 
 	var lastSent time.Time = time.Now().Add(-time.Second)
 	var lastPercent float64 = 0
+	var finalPaths []string
+	// yt-dlp's own error lines, the exit status alone says nothing
+	var errorLines []string
+	// Why yt-dlp skipped the video, it still exits 0
+	var skipReason string
+	var started bool
+	var meter transferMeter
 
 	for {
 		line, err := reader.ReadString('\n')
@@ -94,7 +136,46 @@ func RunYTDownloadWithProgress(ctx context.Context, args []string, requestID str
 			continue
 		}
 
-		fmt.Printf("[yt-dlp] %s\n", line)
+		jobLog.Write([]byte(line + "\n"))
+
+		if path, ok := strings.CutPrefix(line, finalPathPrefix); ok {
+			finalPaths = append(finalPaths, path)
+			continue
+		}
+
+		if strings.HasPrefix(line, startedPrefix) {
+			started = true
+			meter.file()
+			continue
+		}
+
+		if strings.HasPrefix(line, "[download] Destination:") {
+			meter.file()
+			continue
+		}
+
+		if strings.HasPrefix(line, "ERROR:") && len(errorLines) < 5 {
+			errorLines = append(errorLines, line)
+			continue
+		}
+
+		if strings.Contains(line, "max-filesize") || strings.Contains(line, "does not pass filter") {
+			skipReason = line
+			continue
+		}
+
+		// Live recordings have no total size, ffmpeg only reports elapsed time
+		if elapsedMatch := elapsedRegex.FindStringSubmatch(line); len(elapsedMatch) == 2 {
+			if time.Since(lastSent) >= time.Second {
+				sse.Send(requestID, map[string]interface{}{
+					"status":  "recording",
+					"message": "Recording live stream",
+					"elapsed": elapsedMatch[1],
+				})
+				lastSent = time.Now()
+			}
+			continue
+		}
 
 		percentMatch := percentRegex.FindStringSubmatch(line)
 		if len(percentMatch) != 2 {
@@ -107,6 +188,15 @@ func RunYTDownloadWithProgress(ctx context.Context, args []string, requestID str
 		}
 
 		sizeMatch := sizeRegex.FindStringSubmatch(line)
+
+		if strings.HasPrefix(line, "[download]") {
+			var size int64
+			if len(sizeMatch) == 2 {
+				size = parseSize(sizeMatch[1])
+			}
+			meter.progress(percent, size, time.Now())
+		}
+
 		if len(sizeMatch) == 2 {
 			size := sizeMatch[1]
 			if percent == 100 && (strings.Contains(size, "KiB") || strings.Contains(size, "B")) {
@@ -130,7 +220,13 @@ func RunYTDownloadWithProgress(ctx context.Context, args []string, requestID str
 	}
 
 	if err := cmd.Wait(); err != nil {
-		return fmt.Errorf("yt-dlp failed: %w", err)
+		return nil, meter.result(), Classify(err, strings.Join(errorLines, "; "))
+	}
+
+	if len(finalPaths) == 0 {
+		if err := skipped(args, skipReason, started); err != nil {
+			return nil, meter.result(), err
+		}
 	}
 
 	if lastPercent < 100 {
@@ -141,5 +237,116 @@ func RunYTDownloadWithProgress(ctx context.Context, args []string, requestID str
 		})
 	}
 
+	return finalPaths, meter.result(), nil
+}
+
+// skipped tells why a run that exited cleanly left no file. --print makes
+// yt-dlp quiet, so its skip line is usually missing: a video --match-filter
+// rejects never gets to before_dl, one over --max-filesize stops after it.
+func skipped(args []string, reason string, started bool) error {
+	switch {
+	case reason != "":
+		return Classify(errSkipped, reason)
+	case !slices.Contains(args, "after_move:"+finalPathPrefix+"%(filepath)s"):
+		// Nothing to go by, the caller looks for the file itself
+		return nil
+	case !started && slices.Contains(args, "--match-filter"):
+		return NewError(CodeTooLong, errSkipped)
+	case started && slices.Contains(args, "--max-filesize"):
+		return NewError(CodeTooLarge, errSkipped)
+	}
 	return nil
 }
+
+// StreamFormat starts yt-dlp writing formatID of videoURL to w. yt-dlp
+// fetches the media itself, with the cookies, headers and proxy of session.
+// wait returns once it has exited.
+func StreamFormat(ctx context.Context, videoURL, formatID string, session Session, w io.Writer) (wait func() error, err error) {
+	binary, err := deps.Path(deps.YTDLP)
+	if err != nil {
+		return nil, err
+	}
+
+	args, err := NewCommand(videoURL).
+		Session(session).
+		Quiet().
+		Format(formatID).
+		Output("-").
+		Build()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := proc.CommandContext(ctx, proc.Options{Timeout: config.Get().DownloadTimeout}, binary, args...)
+
+	var stderr bytes.Buffer
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		if err := cmd.Wait(); err != nil {
+			return Classify(err, stderr.String())
+		}
+		return nil
+	}, nil
+}
+
+// ResolveFormats asks yt-dlp which direct URLs it would download for format without downloading them
+func ResolveFormats(ctx context.Context, videoURL string, format string, session Session) (*models.ResolvedMedia, error) {
+	binary, err := deps.Path(deps.YTDLP)
+	if err != nil {
+		return nil, err
+	}
+
+	args, err := NewCommand(videoURL).
+		Session(session).
+		DumpJSON().
+		Quiet().
+		Format(format).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := proc.CommandContext(ctx, proc.Options{Timeout: config.Get().InfoTimeout}, binary, args...)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, Classify(err, stderr.String())
+	}
+
+	var data struct {
+		Title            string                  `json:"title"`
+		Duration         float64                 `json:"duration"`
+		IsLive           bool                    `json:"is_live"`
+		RequestedFormats []models.ResolvedFormat `json:"requested_formats"`
+		models.ResolvedFormat
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &data); err != nil {
+		return nil, fmt.Errorf("yt-dlp parse error: %w", err)
+	}
+
+	media := &models.ResolvedMedia{
+		Title:    data.Title,
+		Duration: data.Duration,
+		IsLive:   data.IsLive,
+		Formats:  data.RequestedFormats,
+	}
+
+	// Single file formats are described at the top level
+	if len(media.Formats) == 0 && data.URL != "" {
+		media.Formats = []models.ResolvedFormat{data.ResolvedFormat}
+	}
+	if len(media.Formats) == 0 {
+		return nil, fmt.Errorf("yt-dlp returned no media url")
+	}
+
+	return media, nil
+}
@@ -0,0 +1,130 @@
+package ytdlp
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+// fakeYTDLP puts a yt-dlp on PATH that prints the transcript in testdata
+// and exits with status
+func fakeYTDLP(t *testing.T, transcript string, status string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake yt-dlp is a shell script")
+	}
+
+	abs, err := filepath.Abs(filepath.Join("testdata", transcript))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	script := "#!/bin/sh\ncat '" + abs + "'\nexit " + status + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "yt-dlp"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestRunYTDownloadWithProgress(t *testing.T) {
+	limited := NewCommand(testURL).
+		Progress().
+		PrintFinalPath().
+		MaxFileSize(10 << 20).
+		MatchFilter("duration <= 3600")
+
+	tests := []struct {
+		name       string
+		transcript string
+		status     string
+		cmd        *Command
+		wantPaths  []string
+		wantBytes  int64
+		wantCode   ErrorCode
+	}{
+		{
+			name:       "two formats",
+			transcript: "two_formats.txt",
+			status:     "0",
+			cmd:        limited,
+			wantPaths: []string{
+				"downloads/0123456789abcdef/video.f137.mp4",
+				"downloads/0123456789abcdef/video.f140.m4a",
+			},
+			wantBytes: 11 << 20,
+		},
+		{
+			name:       "quiet match filter skip",
+			transcript: "filtered.txt",
+			status:     "0",
+			cmd:        limited,
+			wantCode:   CodeTooLong,
+		},
+		{
+			name:       "quiet max filesize skip",
+			transcript: "too_large.txt",
+			status:     "0",
+			cmd:        limited,
+			wantCode:   CodeTooLarge,
+		},
+		{
+			name:       "skip line without final path",
+			transcript: "skip_line.txt",
+			status:     "0",
+			cmd:        NewCommand(testURL).Progress().MaxFileSize(10 << 20),
+			wantCode:   CodeTooLarge,
+		},
+		{
+			name:       "no final path and no limits",
+			transcript: "filtered.txt",
+			status:     "0",
+			cmd:        NewCommand(testURL).Progress().PrintFinalPath(),
+		},
+		{
+			name:       "error line",
+			transcript: "private.txt",
+			status:     "1",
+			cmd:        limited,
+			wantCode:   CodePrivate,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeYTDLP(t, tt.transcript, tt.status)
+
+			args, err := tt.cmd.Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			paths, transfer, err := RunYTDownloadWithProgress(context.Background(), args, "0123456789abcdef", 0)
+
+			if tt.wantCode != "" {
+				var ytErr *Error
+				if !errors.As(err, &ytErr) {
+					t.Fatalf("err = %v, want code %s", err, tt.wantCode)
+				}
+				if ytErr.Code != tt.wantCode {
+					t.Fatalf("code = %s, want %s", ytErr.Code, tt.wantCode)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(paths, tt.wantPaths) {
+				t.Fatalf("paths = %q, want %q", paths, tt.wantPaths)
+			}
+			if transfer.Bytes != tt.wantBytes {
+				t.Fatalf("bytes = %d, want %d", transfer.Bytes, tt.wantBytes)
+			}
+		})
+	}
+}
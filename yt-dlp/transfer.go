@@ -0,0 +1,81 @@
+package ytdlp
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Transfer is what one run of yt-dlp fetched, read from its progress lines
+type Transfer struct {
+	Bytes   int64         // fetched by this run, not what an earlier run left
+	Elapsed time.Duration // from the first progress line to the last
+}
+
+// transferMeter adds up the progress lines of every file a run downloads.
+// A resumed file counts from the percentage its first line reports.
+type transferMeter struct {
+	transfer Transfer
+
+	size         int64
+	start, last  float64
+	counting     bool
+	first, final time.Time
+}
+
+// file starts the count of the next file, e.g. on "[download] Destination:"
+func (m *transferMeter) file() {
+	if m.counting && m.size > 0 {
+		m.transfer.Bytes += int64(float64(m.size) * (m.last - m.start) / 100)
+	}
+	m.size, m.start, m.last, m.counting = 0, 0, 0, false
+}
+
+// progress records a line at percent of a file of size bytes, 0 when unknown
+func (m *transferMeter) progress(percent float64, size int64, at time.Time) {
+	// A lower percentage without a destination line is the next file
+	if m.counting && percent < m.last {
+		m.file()
+	}
+	if !m.counting {
+		m.start, m.counting = percent, true
+	}
+	m.last = percent
+	if size > 0 {
+		m.size = size
+	}
+
+	if m.first.IsZero() {
+		m.first = at
+	}
+	m.final = at
+}
+
+func (m *transferMeter) result() Transfer {
+	m.file()
+	m.transfer.Elapsed = m.final.Sub(m.first)
+	return m.transfer
+}
+
+// parseSize reads the sizes yt-dlp prints, "120.50MiB" or "3.2GB"
+func parseSize(s string) int64 {
+	units := []struct {
+		suffix string
+		scale  float64
+	}{
+		{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+		{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9},
+	}
+
+	s = strings.TrimSpace(s)
+	for _, unit := range units {
+		if number, ok := strings.CutSuffix(s, unit.suffix); ok {
+			value, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+			if err != nil {
+				return 0
+			}
+			return int64(value * unit.scale)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,59 @@
+package ytdlp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{
+		"120.00MiB": 120 << 20,
+		"1.5GiB":    3 << 29,
+		"512KiB":    512 << 10,
+		"3.2 MB":    3_200_000,
+		"2GB":       2_000_000_000,
+		"12B":       0,
+		"NAMiB":     0,
+	}
+	for in, want := range tests {
+		if got := parseSize(in); got != want {
+			t.Errorf("parseSize(%q) = %d, want %d", in, got, want)
+		}
+	}
+}
+
+func TestTransferMeter(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	var m transferMeter
+
+	// A video resumed at 40%, then the audio from the start
+	m.file()
+	m.progress(40, 100<<20, start)
+	m.progress(70, 100<<20, start.Add(3*time.Second))
+	m.progress(100, 100<<20, start.Add(6*time.Second))
+	m.file()
+	m.progress(0, 10<<20, start.Add(7*time.Second))
+	m.progress(100, 10<<20, start.Add(10*time.Second))
+
+	got := m.result()
+	if want := int64(60<<20 + 10<<20); got.Bytes != want {
+		t.Errorf("Bytes = %d, want %d", got.Bytes, want)
+	}
+	if got.Elapsed != 10*time.Second {
+		t.Errorf("Elapsed = %s, want 10s", got.Elapsed)
+	}
+}
+
+func TestTransferMeterNextFileWithoutDestination(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	var m transferMeter
+
+	m.progress(0, 8<<20, start)
+	m.progress(100, 8<<20, start.Add(time.Second))
+	m.progress(5, 2<<20, start.Add(2*time.Second))
+	m.progress(100, 2<<20, start.Add(3*time.Second))
+
+	if got, want := m.result().Bytes, int64(8<<20+2<<20*95/100); got != want {
+		t.Errorf("Bytes = %d, want %d", got, want)
+	}
+}